@@ -2,8 +2,15 @@ package multistep
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type runState int32
@@ -14,91 +21,1629 @@ const (
 	stateCancelling
 )
 
+// DefaultMaxReplays is how many times in a row BasicRunner lets a step
+// return ActionReplay if MaxReplays isn't set.
+const DefaultMaxReplays = 3
+
+// ConcurrentRunPolicy is what a BasicRunner does when Run is called while
+// it is already running.
+type ConcurrentRunPolicy int
+
+const (
+	// ConcurrentRunPanic panics.
+	ConcurrentRunPanic ConcurrentRunPolicy = iota
+
+	// ConcurrentRunIgnore returns straight away without running anything.
+	// The RunResult has ErrAlreadyRunning as its Err.
+	ConcurrentRunIgnore
+
+	// ConcurrentRunWait waits for the run in progress to finish, and then
+	// runs as usual.
+	ConcurrentRunWait
+)
+
+// CleanupOrder is the order in which a BasicRunner cleans up the steps
+// that ran.
+type CleanupOrder int
+
+const (
+	// CleanupReverse cleans up the steps in the reverse order that they
+	// ran, so that each step is torn down before the steps it built on.
+	CleanupReverse CleanupOrder = iota
+
+	// CleanupForward cleans up the steps in the order that they ran, for
+	// cleanups that are independent of each other.
+	CleanupForward
+)
+
+// ErrAlreadyRunning is the error in the RunResult of a run that was
+// ignored because the runner was already running.
+var ErrAlreadyRunning = errors.New("runner is already running")
+
 // BasicRunner is a Runner that just runs the given slice of steps.
+//
+// Once the run stops, whether it completed, halted or was cancelled, the
+// steps that ran are cleaned up in the reverse order that they ran, so for
+// steps A, B and C the cleanups are C, B, A. Steps that were never reached,
+// or that returned ActionSkip, are not cleaned up. CleanupRan reports which
+// steps were cleaned up by the last run.
+//
+// The exception is a step that returns ActionAbort: the run stops and no
+// step is cleaned up at all, not even with ForceFullCleanup.
+//
+// A panic in a cleanup is always recovered, whether or not RecoverPanics
+// is set, and recorded as a *PanicError under StateCleanupErrors. The
+// remaining steps are still cleaned up, and the panic never propagates out
+// of Run.
+//
+// A BasicRunner can be run again once a run has finished. The state bag
+// from a previous run still holds StateCancelled or StateHalted if that
+// run was stopped, which would stop the next run straight away, so either
+// give each run a fresh state bag or call ResetState on the old one first.
 type BasicRunner struct {
 	// Steps is a slice of steps to run. Once set, this should _not_ be
-	// modified.
+	// modified. None of the steps may be nil, or Run panics.
 	Steps []Step
 
-	cancel context.CancelFunc
-	doneCh chan struct{}
-	state  runState
-	l      sync.Mutex
+	// Observers are notified before and after each step is run.
+	Observers []StepObserver
+
+	// RecoverPanics, if true, recovers a panic in a step's Run and treats
+	// it as the step halting. A *PanicError is put into the state bag under
+	// StateError and the cleanups run as usual. By default a panic is not
+	// recovered.
+	RecoverPanics bool
+
+	// OnPanic, if set, is called with each panic that the runner recovers,
+	// from a step's Run when RecoverPanics is set and from any cleanup,
+	// along with the stack of the panic. It is called as soon as the panic
+	// is recovered, before the runner carries on with the cleanups, so a
+	// panic can be sent to an error reporter. The step is the one that
+	// was run, wrapped by any Middleware.
+	OnPanic func(index int, step Step, recovered interface{}, stack []byte)
+
+	// RecordTimings, if true, measures how long each step's Run takes and
+	// stores the timings as a []StepTiming under StateStepTimings. Steps
+	// that return ActionSkip are not recorded.
+	RecordTimings bool
+
+	// RecordCleanupTimings, if true, measures how long each step's cleanup
+	// takes and stores the timings as a []StepTiming under
+	// StateCleanupTimings, in the reverse order of the steps, along with
+	// the time.Duration of the whole teardown under StateCleanupDuration.
+	// They are recorded whenever the cleanups run, however the run ended.
+	RecordCleanupTimings bool
+
+	// SkipCleanupOnSuccess, if true, skips cleaning up the steps when the
+	// run completes without being halted or cancelled. Cleanups then only
+	// run to roll back a failed run. Steps that never ran are never cleaned
+	// up, whether or not this is set.
+	SkipCleanupOnSuccess bool
+
+	// Timeout, if non-zero, is how long the whole run may take. Once it
+	// passes, the context given to the steps is cancelled and the run is
+	// treated as cancelled: StateCancelled is set and the cleanups run.
+	Timeout time.Duration
+
+	// StartIndex is the index into Steps of the first step to run, for
+	// resuming a sequence partway through. The steps before it are neither
+	// run nor cleaned up. It must be between zero and len(Steps), or Run
+	// panics.
+	StartIndex int
+
+	// Middleware wraps every step when it is run. The first middleware is
+	// the outermost. The steps are wrapped each time Run is called, so
+	// changes between runs take effect on the next run. Observers and
+	// Validate see the unwrapped steps.
+	Middleware []StepMiddleware
+
+	// Logger, if set, is used to log the start and end of each step at
+	// debug level, and the run being halted or cancelled at warn level.
+	Logger *slog.Logger
+
+	// CleanupTimeout, if non-zero, is the deadline given to the context of
+	// each step implementing StepWithCleanupContext or
+	// StepWithCleanupContextError when it is cleaned up. Each retry of a
+	// cleanup gets a deadline of its own.
+	CleanupTimeout time.Duration
+
+	// CleanupRetry says how to retry the cleanup of a step implementing
+	// StepWithCleanupContextError when it fails, for teardown that can
+	// fail transiently. By default a cleanup is not retried.
+	CleanupRetry CleanupRetry
+
+	// CleanupDeadline, if non-zero, is the total time all the cleanups of
+	// a run may take together. Once it has passed the remaining cleanups
+	// are still called, but steps implementing StepWithCleanupContext get
+	// an expired context so that they can fail fast. The indexes of the
+	// steps whose cleanups finished after the deadline are put, as an
+	// []int in the order the cleanups ran, under StateCleanupOverdue.
+	CleanupDeadline time.Duration
+
+	// ForceFullCleanup, if true, cleans up every step when the run is
+	// halted or cancelled, not just the steps that ran: steps that were
+	// skipped, that opted out of cleanup, or that were never reached are
+	// cleaned up too, one at a time in reverse order of the steps. Before
+	// each cleanup StateStepRan is set to whether that step's Run was
+	// called, so a step can tell what it has to do. The steps before
+	// StartIndex and a step abandoned after CancelGrace are still not
+	// cleaned up.
+	ForceFullCleanup bool
+
+	// ContinueOnHalt, if true, keeps going after a step returns
+	// ActionHalt instead of stopping the run: the failure is appended to
+	// the []StepFailure under StateFailures and the next step runs. If any
+	// step halted, the run ends halted once every step has run, with
+	// StateError and the RunResult's Err holding all the steps' errors
+	// joined together. Halted steps are cleaned up like any other. A
+	// cancel still stops the run straight away.
+	ContinueOnHalt bool
+
+	// NewStateBag, if set, creates the state bag used by RunNew. If nil, a
+	// new BasicStateBag is used.
+	NewStateBag func() StateBag
+
+	// Progress, if set, is told after each step has run how many of the
+	// steps have run so far. The total is the number of steps, and the
+	// count includes the steps skipped by StartIndex. If it is also a
+	// WeightedProgressReporter, it is told the weighted progress too.
+	Progress ProgressReporter
+
+	// MaxReplays is how many times in a row a step may return ActionReplay
+	// before it is treated as halting, with an error under StateError. If
+	// zero or negative, DefaultMaxReplays is used.
+	MaxReplays int
+
+	// CancelGrace, if non-zero, limits how long a cancelled run waits for
+	// the step that is running to return from Run. Once it passes the step
+	// is abandoned, left to return on its own, and the other steps are
+	// cleaned up straight away. An abandoned step is not cleaned up, since
+	// it is still running. By default the runner waits for the step
+	// however long it takes. A step implementing StepWithUninterruptible
+	// is never abandoned.
+	CancelGrace time.Duration
+
+	// ContextFunc, if set, is called before each step to derive the context
+	// given to the step's Run from the run's context, for example to add a
+	// logger for the step. The context it returns must be derived from the
+	// one it is given, so that cancelling the run still reaches the step.
+	// The context it is given already carries the step's index and name,
+	// as returned by StepIndexFromContext and StepNameFromContext, and any
+	// deadline from StateDeadline.
+	ContextFunc func(ctx context.Context, index int, step Step, state StateBag) context.Context
+
+	// Clock, if set, is used for Timeout, CancelGrace, CleanupTimeout and
+	// the timings instead of the real time, for tests.
+	Clock Clock
+
+	// OnComplete, if set, is called once at the very end of each run,
+	// after the cleanups, with a summary of the run. It is called however
+	// the run ended, including when a panic was recovered by
+	// RecoverPanics. A panic that wasn't recovered is reported as
+	// OutcomeHalted with a *PanicError, before the panic carries on.
+	OnComplete func(RunSummary)
+
+	// CleanupOrder is the order in which the steps are cleaned up, by
+	// default CleanupReverse. Either way only the steps that ran are
+	// cleaned up, whether the run completed, halted or was cancelled.
+	// Cleanup groups are still cleaned up from the highest group to the
+	// lowest; the order applies within each group.
+	CleanupOrder CleanupOrder
+
+	// Metadata, if set, describes the whole run, for example the name of
+	// the target environment. A copy of it is put under StateMetadata at
+	// the start of each run, so that steps and observers can read it.
+	Metadata map[string]interface{}
+
+	// ActionFilter, if set, is called with the action returned by each
+	// Run of a step, and returns the action for the runner to act on
+	// instead, for run-wide policies such as turning ActionHalt into
+	// ActionContinue in a best-effort mode. It is called before the action
+	// is checked against OnUnknownAction, and before the observers see it.
+	ActionFilter func(index int, step Step, action StepAction, state StateBag) StepAction
+
+	// OnUnknownAction, if set, is called when a step returns a StepAction
+	// that isn't one of the constants of this package, for example
+	// because of a bug, and returns the action to use instead. If it is
+	// not set, or returns an unknown action itself, the run is halted with
+	// an error under StateError saying which step returned what.
+	OnUnknownAction func(StepAction) StepAction
+
+	// CancelledKey and HaltedKey, if set, are the keys the runner uses in
+	// place of StateCancelled and StateHalted, for a state bag whose users
+	// already have their own meaning for those. Every step, wrapper and
+	// helper reading or setting the keys, such as RunAndWait, RunnerStep
+	// or a step setting StateCancelled to stop the run, must then agree on
+	// the names; those in this package use the defaults.
+	CancelledKey string
+	HaltedKey    string
+
+	// EventSink, if set, is given an Event as each step starts and ends,
+	// and when the run halts, is cancelled or completes.
+	EventSink EventSink
+
+	// ConcurrentRunPolicy is what Run does if it is called while the
+	// runner is already running. By default it panics.
+	ConcurrentRunPolicy ConcurrentRunPolicy
+
+	cancel  context.CancelCauseFunc
+	cleaned []bool
+	current int
+	count   int
+	doneCh  chan struct{}
+	state   runState
+	l       sync.Mutex
+
+	// pending is the cause of a Cancel made while idle, for the next run.
+	pending error
+
+	// called is which steps of the last run had their Run called.
+	called []bool
 }
 
-func (b *BasicRunner) Run(parent context.Context, state StateBag) {
+// CleanupRetry says how a BasicRunner retries a failed cleanup.
+type CleanupRetry struct {
+	// MaxAttempts is the maximum number of times to call the cleanup.
+	// Values less than two mean the cleanup is not retried.
+	MaxAttempts int
+
+	// Backoff returns how long to wait after the given attempt (starting
+	// at 1) fails before trying again. If it is nil, there is no wait.
+	Backoff func(attempt int) time.Duration
+}
+
+// RunResult describes how a single run of a BasicRunner ended.
+type RunResult struct {
+	// Action is the action returned by the last step that was run. If no
+	// step was run this is ActionContinue.
+	Action StepAction
+
+	// Index is the index into Steps of the last step that was run, or -1
+	// if no step was run (for example if the run was cancelled before the
+	// first step started).
+	Index int
+
+	// Name is the name, as given by StepName, of the last step that was
+	// run, or "" if no step was run.
+	Name string
+
+	// Completed is true if every step was run and the sequence was neither
+	// halted nor cancelled.
+	Completed bool
+
+	// Err is why the run stopped early, or nil if it completed. For a halt
+	// it is the error under StateError, or ErrHalted if there is none. For
+	// a cancel it is the cause of the context's cancellation, such as
+	// ErrUserCancel or context.DeadlineExceeded, or ErrCancelled if the
+	// context wasn't cancelled.
+	Err error
+}
+
+// RunSummary summarizes a whole run of a BasicRunner, for OnComplete.
+type RunSummary struct {
+	// Outcome is how the run ended.
+	Outcome Outcome
+
+	// StepsRun is the number of steps whose Run was called, including
+	// those that returned ActionSkip.
+	StepsRun int
+
+	// StepsSkipped is the number of steps that returned ActionSkip.
+	StepsSkipped int
+
+	// Duration is how long the whole run took, cleanups included.
+	Duration time.Duration
+
+	// Err is the Err of the RunResult: nil if the run completed,
+	// otherwise why it stopped.
+	Err error
+}
+
+// StepResult records how a single step of a run ended.
+type StepResult struct {
+	// Index is the index into Steps of the step.
+	Index int
+
+	// Name is the name of the step, as given by StepName.
+	Name string
+
+	// Action is the action the step returned, after any replays.
+	Action StepAction
+
+	// Duration is the wall-clock time taken by the step's Run, replays
+	// included.
+	Duration time.Duration
+
+	// Err is the error under StateError if the step halted, or ErrHalted
+	// if it halted without one. It is nil if the step didn't halt.
+	Err error
+}
+
+// StepFailure records a step that halted a run with ContinueOnHalt set.
+type StepFailure struct {
+	// Index is the index into Steps of the step.
+	Index int
+
+	// Name is the name of the step, as given by StepName.
+	Name string
+
+	// Err is the error the step put under StateError, or ErrHalted if it
+	// didn't put one.
+	Err error
+}
+
+// StepTiming is how long the Run, or the Cleanup, of a single step took.
+type StepTiming struct {
+	// Index is the index into Steps of the step.
+	Index int
+
+	// Duration is the wall-clock time taken by the step's Run, not
+	// including its Cleanup. For the timings of the cleanups, it is the
+	// time taken by the Cleanup alone.
+	Duration time.Duration
+}
+
+// PanicError is the error recorded when a runner recovers a panic.
+type PanicError struct {
+	// Value is the value the panic was called with.
+	Value interface{}
+
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("step panicked: %v", e.Value)
+}
+
+// RunError is the error returned by RunE when a run didn't complete. Use
+// errors.As to get at it, and errors.Is to match the error it wraps.
+type RunError struct {
+	// Outcome is how the run ended: halted, cancelled or aborted.
+	Outcome Outcome
+
+	// Index and Name are those of the last step that was run, as in
+	// RunResult. Index is -1 if no step was run.
+	Index int
+	Name  string
+
+	// Err is why the run stopped, the Err of the RunResult.
+	Err error
+}
+
+// Error returns the message of Err, so that a RunError reads the same as
+// the error it wraps.
+func (e *RunError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+func (b *BasicRunner) Run(ctx context.Context, state StateBag) {
+	b.RunWithResult(ctx, state)
+}
+
+// RunE runs the steps exactly like Run. It returns nil if the run
+// completed, or otherwise a *RunError saying where the run stopped and
+// wrapping the Err of the RunResult. A run ignored by ConcurrentRunIgnore
+// returns ErrAlreadyRunning itself, since it never ran at all.
+func (b *BasicRunner) RunE(ctx context.Context, state StateBag) error {
+	result := b.RunWithResult(ctx, state)
+	if result.Err == nil || result.Err == ErrAlreadyRunning {
+		return result.Err
+	}
+
+	return &RunError{
+		Outcome: outcomeOfKeys(state, b.cancelledKey(), b.haltedKey()),
+		Index:   result.Index,
+		Name:    result.Name,
+		Err:     result.Err,
+	}
+}
+
+// RunNew runs the steps with a new state bag, created by NewStateBag, and
+// returns the bag once the run is over.
+func (b *BasicRunner) RunNew(ctx context.Context) StateBag {
+	var state StateBag = new(BasicStateBag)
+	if b.NewStateBag != nil {
+		state = b.NewStateBag()
+	}
+
+	b.Run(ctx, state)
+	return state
+}
+
+// RunWithResult runs the steps exactly like Run, but also returns a
+// RunResult describing where and how the sequence ended.
+func (b *BasicRunner) RunWithResult(parent context.Context, state StateBag) RunResult {
+	return b.run(parent, state, b.Steps)
+}
+
+// RunSteps runs the given steps in place of Steps, for this run only,
+// exactly like Run. This lets one runner, with its options, run different
+// lists of steps. As with Run, it panics if the runner is already running.
+func (b *BasicRunner) RunSteps(ctx context.Context, state StateBag, steps []Step) RunResult {
+	return b.run(ctx, state, steps)
+}
+
+// RunContext starts running the steps in the background, exactly like Run,
+// and returns straight away with a function that cancels the run and a
+// channel that is closed once the run is over, cleanups and all. This
+// lets the run be part of a larger cancellation graph without going
+// through Cancel.
+//
+// Calling the cancel function has the same effect on the run as Cancel,
+// including ErrUserCancel as the cause, except that it doesn't wait for
+// the run to finish. It may be called more than once, and after the run
+// is over, when it does nothing. As with Run, RunContext panics if the
+// runner is already running, unless ConcurrentRunPolicy says otherwise;
+// the panic is raised by RunContext itself, not on the background
+// goroutine. Unless ConcurrentRunPolicy is ConcurrentRunWait, the runner
+// is marked as running before RunContext returns.
+func (b *BasicRunner) RunContext(ctx context.Context, state StateBag) (context.CancelFunc, <-chan struct{}) {
+	b.checkSteps(b.Steps)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	doneCh := make(chan struct{})
+
+	// The run is started here, rather than on the goroutine, so that a
+	// panic because the runner is already running is raised to the
+	// caller. A nil value means the run started, or was ignored.
+	startCh := make(chan interface{}, 1)
+	go func() {
+		defer close(doneCh)
+		defer cancel(context.Canceled)
+
+		started := false
+		defer func() {
+			if !started {
+				startCh <- recover()
+			}
+		}()
+
+		b.runClaimed(ctx, state, b.Steps, func() {
+			started = true
+			startCh <- nil
+		})
+	}()
+
+	// With ConcurrentRunWait the run may not start for a while, and it
+	// never panics for being already running.
+	if b.ConcurrentRunPolicy != ConcurrentRunWait {
+		if r := <-startCh; r != nil {
+			panic(r)
+		}
+	}
+
+	return func() { cancel(ErrUserCancel) }, doneCh
+}
+
+// checkSteps panics if the steps can't be run from StartIndex.
+func (b *BasicRunner) checkSteps(steps []Step) {
+	if b.StartIndex < 0 || b.StartIndex > len(steps) {
+		panic(fmt.Sprintf("multistep: StartIndex %d out of range [0, %d]", b.StartIndex, len(steps)))
+	}
+
+	for i, step := range steps {
+		if step == nil {
+			panic(fmt.Sprintf("multistep: Steps[%d] is nil", i))
+		}
+	}
+}
+
+// run runs the given steps. It is the body of both RunWithResult and
+// RunSteps.
+func (b *BasicRunner) run(parent context.Context, state StateBag, steps []Step) RunResult {
+	return b.runClaimed(parent, state, steps, nil)
+}
+
+// runClaimed is run, calling started, if it is set, once the runner is
+// marked as running and before any step is run.
+func (b *BasicRunner) runClaimed(parent context.Context, state StateBag, steps []Step, started func()) RunResult {
+	b.checkSteps(steps)
+
 	b.l.Lock()
-	if b.state != stateIdle {
-		panic("already running")
+	for b.state != stateIdle {
+		switch b.ConcurrentRunPolicy {
+		case ConcurrentRunIgnore:
+			b.l.Unlock()
+			return RunResult{Index: -1, Err: ErrAlreadyRunning}
+		case ConcurrentRunWait:
+			doneCh := b.doneCh
+			b.l.Unlock()
+			<-doneCh
+			b.l.Lock()
+		default:
+			b.l.Unlock()
+			panic("already running")
+		}
 	}
 
-	ctx, cancel := context.WithCancel(parent)
+	ctx, cancel := context.WithCancelCause(parent)
+	release := func() { cancel(context.Canceled) }
+	if b.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = withClockTimeout(ctx, b.Clock, b.Timeout)
+		release = func() {
+			cancelTimeout()
+			cancel(context.Canceled)
+		}
+	}
+	ctx = WithStateBag(ctx, state)
+	clock := clockOrReal(b.Clock)
+
+	if _, ok := state.GetOk(StateRunID); !ok {
+		state.Put(StateRunID, newRunID())
+	}
+
+	if b.Metadata != nil {
+		metadata := make(map[string]interface{}, len(b.Metadata))
+		for k, v := range b.Metadata {
+			metadata[k] = v
+		}
+		state.Put(StateMetadata, metadata)
+	}
+
+	start := clock.Now()
+	var summary RunSummary
+	var outcome Outcome
+	result := RunResult{Index: -1}
 
 	doneCh := make(chan struct{})
 	b.cancel = cancel
+	b.current = -1
+	b.count = len(steps)
 	b.doneCh = doneCh
-	b.state = stateRunning
+	b.called = nil
+	b.setState(stateRunning)
+	if b.pending != nil {
+		// Cancelled before it started, so no step runs
+		cancel(b.pending)
+		b.pending = nil
+		b.setState(stateCancelling)
+		state.Put(b.cancelledKey(), true)
+	}
 	b.l.Unlock()
 
+	if started != nil {
+		started()
+	}
+
+	watchDoneCh := make(chan struct{})
 	defer func() {
 		b.l.Lock()
 		b.cancel = nil
 		b.doneCh = nil
-		b.state = stateIdle
+		b.setState(stateIdle)
 		close(doneCh)
 		b.l.Unlock()
+
+		// Release the context now that we're done with it. The goroutine
+		// below has already been stopped, so this isn't taken for a cancel.
+		release()
+
+		if b.OnComplete != nil {
+			summary.Outcome = outcome
+			summary.Duration = clock.Now().Sub(start)
+			summary.Err = result.Err
+
+			// A panic that RecoverPanics didn't recover is reported as a
+			// halt, then carries on.
+			if r := recover(); r != nil {
+				summary.Outcome = OutcomeHalted
+				summary.Err = &PanicError{Value: r, Stack: debug.Stack()}
+				b.OnComplete(summary)
+				panic(r)
+			}
+
+			b.OnComplete(summary)
+		}
 	}()
 
 	// This goroutine listens for cancels and puts the StateCancelled key
-	// as quickly as possible into the state bag to mark it.
+	// as quickly as possible into the state bag to mark it. It is stopped
+	// once the steps are done, so a cancel during the cleanups doesn't
+	// mark a run that already finished.
+	stopWatch := make(chan struct{})
+	var watchPut bool
 	go func() {
+		defer close(watchDoneCh)
+
 		select {
 		case <-ctx.Done():
-			// Flag cancel and wait for finish
-			state.Put(StateCancelled, true)
-			<-doneCh
-		case <-doneCh:
+			select {
+			case <-stopWatch:
+				return
+			default:
+			}
+
+			// Flag cancel and wait for the steps to finish
+			state.Put(b.cancelledKey(), true)
+			watchPut = true
+			<-stopWatch
+		case <-stopWatch:
 		}
 	}()
 
-	for _, step := range b.Steps {
+	// The steps that ran and need cleaning up, in the order they ran.
+	var ran []ranStep
+
+	// The wrapped steps whose Run was called, by index, and the step that
+	// was abandoned, if any, for ForceFullCleanup.
+	reached := make(map[int]Step)
+	abandonedIndex := -1
+
+	// The earliest deadline put under StateDeadline so far.
+	var deadline time.Time
+
+	var timings []StepTiming
+	var failures []StepFailure
+	var stepResults []StepResult
+
+	cleaned := make([]bool, len(steps))
+	called := make([]bool, len(steps))
+	defer func() {
+		b.l.Lock()
+		b.cleaned = cleaned
+		b.called = called
+		b.l.Unlock()
+	}()
+
+	defer func() {
+		close(stopWatch)
+		<-watchDoneCh
+
+		// The goroutine above may have spotted a cancel that came after
+		// the last step was done.
+		if result.Completed && watchPut {
+			state.Remove(b.cancelledKey())
+		}
+
+		outcome = outcomeOfKeys(state, b.cancelledKey(), b.haltedKey())
+		if result.Completed {
+			outcome = OutcomeCompleted
+		}
+
+		if b.SkipCleanupOnSuccess && result.Completed {
+			return
+		}
+
+		if result.Action == ActionAbort {
+			if b.Logger != nil {
+				b.Logger.LogAttrs(ctx, slog.LevelWarn, "run aborted, skipping cleanups", slog.Int("steps", len(ran)))
+			}
+			return
+		}
+
+		action := ActionHalt
+		if result.Completed {
+			action = ActionContinue
+		}
+
+		full := b.ForceFullCleanup && !result.Completed
+		if full {
+			ran = b.allSteps(steps, reached, abandonedIndex)
+		}
+
+		// Cleanups must be able to finish even if the run was cancelled,
+		// so their context doesn't inherit the cancel.
+		b.cleanupSteps(context.WithoutCancel(ctx), ran, state, action, cleaned, full)
+	}()
+
+	for i := b.StartIndex; i < len(steps); i++ {
+		step := steps[i]
+
+		b.l.Lock()
+		b.current = i
+		b.l.Unlock()
+
 		// We also check for cancellation here since we can't be sure
-		// the goroutine that is running to set it actually ran.
-		if runState(atomic.LoadInt32((*int32)(&b.state))) == stateCancelling {
-			state.Put(StateCancelled, true)
-			break
+		// the goroutine that is running to set it actually ran. Checking
+		// the context covers a parent context that was cancelled before
+		// the run even started, so no step is run at all.
+		if b.getState() == stateCancelling || ctx.Err() != nil {
+			state.Put(b.cancelledKey(), true)
+			result.Err = cancelErr(ctx)
+			b.logCancelled(ctx, result)
+			b.emitEnd(clock, EventCancel, result)
+			return result
 		}
 
-		action := step.Run(ctx, state)
-		defer step.Cleanup(state)
+		for _, o := range b.Observers {
+			o.StepStart(i, step, state)
+		}
+		b.emit(clock, Event{Type: EventStepStart, Index: i, Name: StepName(step)})
+
+		if b.Logger != nil {
+			b.Logger.LogAttrs(ctx, slog.LevelDebug, "step start", stepAttrs(i, step)...)
+		}
+
+		wrapped := wrapStep(step, b.Middleware)
+
+		if d, ok := state.Get(StateDeadline).(time.Time); ok && (deadline.IsZero() || d.Before(deadline)) {
+			deadline = d
+		}
+
+		stepCtx := withStep(ctx, i, StepName(step))
+		cancelStep := func() {}
+		if !deadline.IsZero() {
+			stepCtx, cancelStep = withClockDeadline(stepCtx, b.Clock, deadline)
+		}
+
+		if b.ContextFunc != nil {
+			stepCtx = b.ContextFunc(stepCtx, i, step, state)
+		}
+
+		// An uninterruptible step is never abandoned either.
+		graceCtx := ctx
+		if s, ok := optionalStep(step).(StepWithUninterruptible); ok && s.Uninterruptible() {
+			stepCtx = context.WithoutCancel(stepCtx)
+			graceCtx = context.WithoutCancel(ctx)
+		}
+
+		stepStart := clock.Now()
+		action, abandoned := b.runStep(graceCtx, stepCtx, i, wrapped, state)
+		action = b.stepAction(action, i, step, state)
+		for replays := 0; action == ActionReplay; replays++ {
+			if ctx.Err() != nil {
+				action = ActionHalt
+				break
+			}
+
+			if replays >= b.maxReplays() {
+				action = Halt(state, fmt.Errorf("step replayed more than %d times", replays))
+				break
+			}
+
+			action, abandoned = b.runStep(graceCtx, stepCtx, i, wrapped, state)
+			action = b.stepAction(action, i, step, state)
+		}
+
+		duration := clock.Now().Sub(stepStart)
+		cancelStep()
+		if b.RecordTimings && action != ActionSkip {
+			timings = append(timings, StepTiming{Index: i, Duration: duration})
+			state.Put(StateStepTimings, timings)
+		}
+
+		var stepErr error
+		if action == ActionHalt || action == ActionAbort {
+			stepErr = GetError(state)
+			if stepErr == nil {
+				stepErr = ErrHalted
+			}
+		}
+
+		stepResults = append(stepResults, StepResult{
+			Index:    i,
+			Name:     StepName(step),
+			Action:   action,
+			Duration: duration,
+			Err:      stepErr,
+		})
+		state.Put(StateStepResults, stepResults)
+
+		for _, o := range b.Observers {
+			o.StepEnd(i, step, action, state)
+		}
+		b.emit(clock, Event{Type: EventStepEnd, Index: i, Name: StepName(step), Action: action.String()})
+
+		if b.Progress != nil {
+			b.Progress.Progress(i+1, len(steps))
+			if w, ok := b.Progress.(WeightedProgressReporter); ok {
+				w.WeightedProgress(totalWeight(steps[:i+1]), totalWeight(steps))
+			}
+		}
+
+		if b.Logger != nil {
+			attrs := append(stepAttrs(i, step), slog.String("action", action.String()))
+			b.Logger.LogAttrs(ctx, slog.LevelDebug, "step end", attrs...)
+		}
+
+		if abandoned {
+			abandonedIndex = i
+		} else {
+			reached[i] = wrapped
+		}
 
-		if _, ok := state.GetOk(StateCancelled); ok {
-			break
+		called[i] = true
+		summary.StepsRun++
+		if action == ActionSkip {
+			summary.StepsSkipped++
+		}
+
+		if action == ActionAbort {
+			state.Put(StateAborted, true)
+			state.Put(b.haltedKey(), true)
+			result.Action = action
+			result.Index = i
+			result.Name = StepName(step)
+			result.Err = stepErr
+			if result.Err == ErrHalted {
+				result.Err = ErrAborted
+			}
+
+			if b.Logger != nil {
+				b.Logger.LogAttrs(ctx, slog.LevelError, "run aborted", stepAttrs(i, step)...)
+			}
+			b.emitEnd(clock, EventAbort, result)
+			return result
+		}
+
+		if action != ActionSkip && !abandoned && !skipCleanupOnHalt(step, action) {
+			ran = append(ran, ranStep{Index: i, Name: StepName(step), Step: wrapped, Inner: step, Ran: true})
+		}
+
+		result.Action = action
+		result.Index = i
+		result.Name = StepName(step)
+
+		// Run any steps this step added right after it.
+		if next, ok := state.GetOk(StateNextSteps); ok {
+			state.Remove(StateNextSteps)
+			if next, ok := next.([]Step); ok && len(next) > 0 && action != ActionHalt {
+				for j, s := range next {
+					if s == nil {
+						panic(fmt.Sprintf("multistep: StateNextSteps[%d] added by step %d is nil", j, i))
+					}
+				}
+
+				steps = insertSteps(steps, i+1, next)
+				cleaned = append(cleaned[:i+1:i+1], append(make([]bool, len(next)), cleaned[i+1:]...)...)
+				called = append(called[:i+1:i+1], append(make([]bool, len(next)), called[i+1:]...)...)
+
+				b.l.Lock()
+				b.count = len(steps)
+				b.l.Unlock()
+			}
+		}
+
+		// The context may have been cancelled by the parent rather than
+		// by Cancel, in which case the goroutine may not have flagged it
+		// yet.
+		if ctx.Err() != nil {
+			state.Put(b.cancelledKey(), true)
+		}
+
+		if _, ok := state.GetOk(b.cancelledKey()); ok {
+			result.Err = cancelErr(ctx)
+			b.logCancelled(ctx, result)
+			b.emitEnd(clock, EventCancel, result)
+			return result
+		}
+
+		if action == ActionHalt && b.ContinueOnHalt {
+			// Clear the error so the next failure doesn't pick it up.
+			state.Remove(StateError)
+			failures = append(failures, StepFailure{Index: i, Name: StepName(step), Err: stepErr})
+			state.Put(StateFailures, failures)
+
+			if b.Logger != nil {
+				b.Logger.LogAttrs(ctx, slog.LevelWarn, "step halted, continuing", stepAttrs(i, step)...)
+			}
+			continue
 		}
 
 		if action == ActionHalt {
-			state.Put(StateHalted, true)
-			break
+			state.Put(b.haltedKey(), true)
+			result.Err = GetError(state)
+			if result.Err == nil {
+				result.Err = ErrHalted
+			}
+
+			if b.Logger != nil {
+				b.Logger.LogAttrs(ctx, slog.LevelWarn, "run halted", stepAttrs(i, step)...)
+			}
+			b.emitEnd(clock, EventHalt, result)
+			return result
+		}
+	}
+
+	// With no step left to run, a cancel from before the run started, such
+	// as an idle Cancel, hasn't been seen by the loop yet.
+	if b.getState() == stateCancelling || ctx.Err() != nil {
+		state.Put(b.cancelledKey(), true)
+		result.Err = cancelErr(ctx)
+		b.logCancelled(ctx, result)
+		b.emitEnd(clock, EventCancel, result)
+		return result
+	}
+
+	if len(failures) > 0 {
+		errs := make([]error, len(failures))
+		for i, f := range failures {
+			errs[i] = f.Err
+		}
+
+		last := failures[len(failures)-1]
+		result.Action = ActionHalt
+		result.Index = last.Index
+		result.Name = last.Name
+		result.Err = errors.Join(errs...)
+		state.Put(StateError, result.Err)
+		state.Put(b.haltedKey(), true)
+
+		if b.Logger != nil {
+			b.Logger.LogAttrs(ctx, slog.LevelWarn, "run halted", slog.Int("failures", len(failures)))
+		}
+		b.emitEnd(clock, EventHalt, result)
+		return result
+	}
+
+	result.Completed = true
+	b.emitEnd(clock, EventComplete, result)
+	return result
+}
+
+// emit gives the event to the EventSink, if there is one, stamped with the
+// time.
+func (b *BasicRunner) emit(clock Clock, e Event) {
+	if b.EventSink != nil {
+		e.Time = clock.Now()
+		b.EventSink.Emit(e)
+	}
+}
+
+// emitEnd emits the event of the given type for the end of a run.
+func (b *BasicRunner) emitEnd(clock Clock, typ string, result RunResult) {
+	e := Event{Type: typ, Index: result.Index, Name: result.Name}
+	if result.Err != nil {
+		e.Error = result.Err.Error()
+	}
+
+	b.emit(clock, e)
+}
+
+// stepAction returns the action to act on for the action returned by the
+// step, as rewritten by ActionFilter and checked by knownAction.
+func (b *BasicRunner) stepAction(action StepAction, index int, step Step, state StateBag) StepAction {
+	if b.ActionFilter != nil {
+		action = b.ActionFilter(index, step, action, state)
+	}
+
+	return b.knownAction(action, index, step, state)
+}
+
+// knownAction returns the action if it is one the runner knows. Otherwise
+// it is mapped by OnUnknownAction, or the run is halted with an error.
+func (b *BasicRunner) knownAction(action StepAction, index int, step Step, state StateBag) StepAction {
+	if isKnownAction(action) {
+		return action
+	}
+
+	if b.OnUnknownAction != nil {
+		if mapped := b.OnUnknownAction(action); isKnownAction(mapped) {
+			return mapped
+		}
+	}
+
+	return Halt(state, fmt.Errorf("multistep: step %d (%s) returned unknown action %v", index, StepName(step), action))
+}
+
+// isKnownAction reports whether the action is one of the StepActions
+// defined by this package.
+func isKnownAction(action StepAction) bool {
+	switch action {
+	case ActionContinue, ActionHalt, ActionSkip, ActionReplay, ActionAbort:
+		return true
+	}
+
+	return false
+}
+
+func (b *BasicRunner) cancelledKey() string {
+	if b.CancelledKey == "" {
+		return StateCancelled
+	}
+
+	return b.CancelledKey
+}
+
+func (b *BasicRunner) haltedKey() string {
+	if b.HaltedKey == "" {
+		return StateHalted
+	}
+
+	return b.HaltedKey
+}
+
+func (b *BasicRunner) maxReplays() int {
+	if b.MaxReplays <= 0 {
+		return DefaultMaxReplays
+	}
+
+	return b.MaxReplays
+}
+
+// Use adds the given middleware to the runner, after any it already has.
+// It must not be called while the runner is running.
+func (b *BasicRunner) Use(mw ...StepMiddleware) {
+	b.Middleware = append(b.Middleware, mw...)
+}
+
+// Validate calls Validate on every step that implements Validatable,
+// without running anything, and returns the errors. Each error is wrapped
+// to say which step it came from.
+func (b *BasicRunner) Validate(state StateBag) []error {
+	var errs []error
+	for i, step := range b.Steps {
+		v, ok := optionalStep(step).(Validatable)
+		if !ok {
+			continue
+		}
+
+		if err := v.Validate(state); err != nil {
+			errs = append(errs, fmt.Errorf("step %d (%s): %w", i, StepName(step), err))
 		}
 	}
+
+	return errs
 }
 
+// ValidateAndRun calls Validate and, only if there are no errors, runs the
+// steps exactly like Run and returns nil. Otherwise it returns the errors
+// from Validate and no step is run, so a plan that could never succeed
+// isn't half run. How the run ended is in the state bag, as with Run.
+func (b *BasicRunner) ValidateAndRun(ctx context.Context, state StateBag) []error {
+	if errs := b.Validate(state); len(errs) > 0 {
+		return errs
+	}
+
+	b.Run(ctx, state)
+	return nil
+}
+
+// IsRunning returns true if the runner is currently running, including
+// while it is being cancelled.
+func (b *BasicRunner) IsRunning() bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	return b.state != stateIdle
+}
+
+// IsCancelling returns true if the runner has been cancelled and is
+// waiting for the current run to finish.
+func (b *BasicRunner) IsCancelling() bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	return b.state == stateCancelling
+}
+
+// CurrentIndex returns the index of the step being run, or -1 if the
+// runner isn't running. Once the last step to run has returned, it stays
+// at that step while the steps are cleaned up.
+func (b *BasicRunner) CurrentIndex() int {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if b.state == stateIdle {
+		return -1
+	}
+
+	return b.current
+}
+
+// NumSteps returns the number of Steps, for inspecting the runner without
+// touching the slice. Unlike StepCount it ignores any steps added during a
+// run under StateNextSteps. It is safe to call before Run.
+func (b *BasicRunner) NumSteps() int {
+	return len(b.Steps)
+}
+
+// StepAt returns Steps[i], or nil if i is out of range. It is safe to call
+// before Run.
+func (b *BasicRunner) StepAt(i int) Step {
+	if i < 0 || i >= len(b.Steps) {
+		return nil
+	}
+
+	return b.Steps[i]
+}
+
+// StepCount returns the number of steps being run, or the number of Steps
+// if the runner isn't running.
+func (b *BasicRunner) StepCount() int {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if b.state == stateIdle {
+		return len(b.Steps)
+	}
+
+	return b.count
+}
+
+// CleanupRan returns true if the step at the given index into Steps, or
+// into the steps given to RunSteps, was cleaned up during the last run. It
+// returns false for an index that is out of range, or if the runner has
+// never been run.
+func (b *BasicRunner) CleanupRan(index int) bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	return index >= 0 && index < len(b.cleaned) && b.cleaned[index]
+}
+
+// RanSteps returns, for each step of the last run, whether its Run was
+// called, indexed like Steps or the steps given to RunSteps, including any
+// added through StateNextSteps. Steps that returned ActionSkip count as
+// run, while steps before StartIndex or never reached don't. It returns
+// nil while the runner is running or if it has never been run.
+func (b *BasicRunner) RanSteps() []bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	return append([]bool(nil), b.called...)
+}
+
+// ranStep is a step to clean up, along with its index into Steps, the
+// step before Middleware wrapped it and its name, and whether its Run was
+// called.
+type ranStep struct {
+	Index int
+	Name  string
+	Step  Step
+	Inner Step
+	Ran   bool
+}
+
+// allSteps returns every step from StartIndex on for ForceFullCleanup,
+// whether or not it ran, except for a step that was abandoned while still
+// running. Steps that ran are the same wrapped steps that were run.
+func (b *BasicRunner) allSteps(steps []Step, reached map[int]Step, abandonedIndex int) []ranStep {
+	var result []ranStep
+	for i := b.StartIndex; i < len(steps); i++ {
+		if i == abandonedIndex {
+			continue
+		}
+
+		wrapped, ok := reached[i]
+		if !ok {
+			wrapped = wrapStep(steps[i], b.Middleware)
+		}
+
+		result = append(result, ranStep{Index: i, Name: StepName(steps[i]), Step: wrapped, Inner: steps[i], Ran: ok})
+	}
+
+	return result
+}
+
+// cleanupSteps cleans up the given steps, marking each one in cleaned.
+// The steps are cleaned up group by group, highest group first, as
+// described by StepWithCleanupGroup; without groups this is simply the
+// reverse order. Errors from the cleanups, including recovered panics, are
+// stored under StateCleanupErrors in the reverse order of the steps.
+//
+// If full is set, for ForceFullCleanup, the steps are cleaned up one at a
+// time in reverse order, ignoring groups, with StateStepRan set before
+// each cleanup.
+func (b *BasicRunner) cleanupSteps(ctx context.Context, steps []ranStep, state StateBag, outcome StepAction, cleaned []bool, full bool) {
+	clock := clockOrReal(b.Clock)
+	start := clock.Now()
+	if b.CleanupDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = withClockTimeout(ctx, b.Clock, b.CleanupDeadline)
+		defer cancel()
+	}
+
+	errs := make([]error, len(steps))
+	durations := make([]time.Duration, len(steps))
+	overdue := make([]bool, len(steps))
+	clean := func(i int) {
+		cleaned[steps[i].Index] = true
+		if full {
+			state.Put(StateStepRan, steps[i].Ran)
+		}
+
+		stepStart := clock.Now()
+		errs[i] = b.cleanupStep(ctx, steps[i], state, outcome)
+		durations[i] = clock.Now().Sub(stepStart)
+		overdue[i] = b.CleanupDeadline > 0 && ctx.Err() != nil
+	}
+
+	groups := cleanupGroups(steps)
+	if full {
+		all := cleanupGroup{Group: 0}
+		for i := len(steps) - 1; i >= 0; i-- {
+			all.Steps = append(all.Steps, i)
+		}
+		groups = []cleanupGroup{all}
+	}
+
+	if b.CleanupOrder == CleanupForward {
+		for _, group := range groups {
+			for i, j := 0, len(group.Steps)-1; i < j; i, j = i+1, j-1 {
+				group.Steps[i], group.Steps[j] = group.Steps[j], group.Steps[i]
+			}
+		}
+	}
+
+	for _, group := range groups {
+		if group.Group == 0 {
+			for _, i := range group.Steps {
+				clean(i)
+			}
+			continue
+		}
+
+		var wg sync.WaitGroup
+		for _, i := range group.Steps {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				clean(i)
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	var result []error
+	var timings []StepTiming
+	var late []int
+	for n := range errs {
+		i := len(errs) - 1 - n
+		if b.CleanupOrder == CleanupForward {
+			i = n
+		}
+
+		if errs[i] != nil {
+			result = append(result, errs[i])
+		}
+
+		timings = append(timings, StepTiming{Index: steps[i].Index, Duration: durations[i]})
+		if overdue[i] {
+			late = append(late, steps[i].Index)
+		}
+	}
+
+	if len(result) > 0 {
+		state.Put(StateCleanupErrors, result)
+	}
+
+	if len(late) > 0 {
+		state.Put(StateCleanupOverdue, late)
+	}
+
+	if b.RecordCleanupTimings {
+		state.Put(StateCleanupTimings, timings)
+		state.Put(StateCleanupDuration, clock.Now().Sub(start))
+	}
+}
+
+// insertSteps returns a copy of steps with next inserted at index i. The
+// given slice is never modified, since it may be Steps.
+func insertSteps(steps []Step, i int, next []Step) []Step {
+	result := make([]Step, 0, len(steps)+len(next))
+	result = append(result, steps[:i]...)
+	result = append(result, next...)
+	return append(result, steps[i:]...)
+}
+
+// newRunID returns a random (version 4) UUID for StateRunID.
+func newRunID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// skipCleanupOnHalt reports whether the step opted out of being cleaned up
+// after halting with the given action.
+func skipCleanupOnHalt(step Step, action StepAction) bool {
+	s, ok := optionalStep(step).(StepWithCleanupOnHalt)
+	return ok && action == ActionHalt && !s.CleanupOnHalt()
+}
+
+// cleanupGroup is a set of steps that are cleaned up together, given as
+// indexes into the steps that ran.
+type cleanupGroup struct {
+	Group int
+	Steps []int
+}
+
+// cleanupGroups splits the steps that ran into their cleanup groups,
+// ordered from the highest group to the lowest. Within each group the
+// steps are in reverse order.
+func cleanupGroups(steps []ranStep) []cleanupGroup {
+	var groups []cleanupGroup
+	for i := len(steps) - 1; i >= 0; i-- {
+		group := 0
+		if s, ok := optionalStep(steps[i].Inner).(StepWithCleanupGroup); ok {
+			group = s.CleanupGroup()
+		}
+
+		j := sort.Search(len(groups), func(j int) bool { return groups[j].Group <= group })
+		if j == len(groups) || groups[j].Group != group {
+			groups = append(groups, cleanupGroup{})
+			copy(groups[j+1:], groups[j:])
+			groups[j] = cleanupGroup{Group: group}
+		}
+		groups[j].Steps = append(groups[j].Steps, i)
+	}
+
+	return groups
+}
+
+// cleanupStep cleans up a single step using the first cleanup method it
+// implements out of CleanupWithContextError, CleanupWithContext,
+// CleanupWithError, CleanupWithOutcome and Cleanup. Like every optional
+// interface, these are looked up on the step before Middleware wrapped it;
+// only a plain Cleanup goes through the middleware. A panic is recovered
+// and returned as a *PanicError so that the remaining steps can still be
+// cleaned up.
+func (b *BasicRunner) cleanupStep(ctx context.Context, ran ranStep, state StateBag, outcome StepAction) (err error) {
+	step := ran.Step
+	defer func() {
+		if r := recover(); r != nil {
+			err = b.recovered(ran.Index, step, r)
+		}
+	}()
+
+	ctx = withStep(ctx, ran.Index, ran.Name)
+	switch s := optionalStep(ran.Inner).(type) {
+	case StepWithCleanupContextError:
+		return b.cleanupRetry(ctx, s, state)
+	case StepWithCleanupContext:
+		ctx, cancel := b.cleanupContext(ctx)
+		defer cancel()
+
+		s.CleanupWithContext(ctx, state)
+	case StepWithCleanupError:
+		return s.CleanupWithError(state)
+	case StepWithOutcome:
+		s.CleanupWithOutcome(state, outcome)
+	default:
+		step.Cleanup(state)
+	}
+
+	return nil
+}
+
+// cleanupRetry cleans up the step, retrying as CleanupRetry says until it
+// succeeds, returning the error of the last attempt. The retries stop
+// early if the context is done, for example after CleanupDeadline.
+func (b *BasicRunner) cleanupRetry(ctx context.Context, step StepWithCleanupContextError, state StateBag) error {
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := b.cleanupContext(ctx)
+		err := step.CleanupWithContextError(attemptCtx, state)
+		cancel()
+
+		if err == nil || attempt >= b.CleanupRetry.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		var d time.Duration
+		if b.CleanupRetry.Backoff != nil {
+			d = b.CleanupRetry.Backoff(attempt)
+		}
+
+		select {
+		case <-clockOrReal(b.Clock).After(d):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// cleanupContext returns the context for a single cleanup, with the
+// CleanupTimeout if there is one, measured with the Clock.
+func (b *BasicRunner) cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.CleanupTimeout > 0 {
+		return withClockTimeout(ctx, b.Clock, b.CleanupTimeout)
+	}
+
+	return ctx, func() {}
+}
+
+// cancelErr returns the error for a run that was cancelled.
+func cancelErr(ctx context.Context) error {
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+
+	return ErrCancelled
+}
+
+// logCancelled logs that the run was cancelled, if there is a Logger.
+func (b *BasicRunner) logCancelled(ctx context.Context, result RunResult) {
+	if b.Logger != nil {
+		b.Logger.LogAttrs(ctx, slog.LevelWarn, "run cancelled", slog.Int("index", result.Index))
+	}
+}
+
+// stepAttrs returns the attributes used to log a step.
+func stepAttrs(index int, step Step) []slog.Attr {
+	return []slog.Attr{
+		slog.Int("index", index),
+		slog.String("name", StepName(step)),
+	}
+}
+
+// getState atomically reads the state of the runner. The state is read
+// without the lock while running, so every write must go through setState.
+func (b *BasicRunner) getState() runState {
+	return runState(atomic.LoadInt32((*int32)(&b.state)))
+}
+
+// setState atomically writes the state of the runner. Callers must hold
+// the lock.
+func (b *BasicRunner) setState(s runState) {
+	atomic.StoreInt32((*int32)(&b.state), int32(s))
+}
+
+// runStep runs a single step with the given context. If CancelGrace is
+// set and runCtx, the context of the run, is cancelled, it gives up
+// waiting for the step once the grace period is over and returns
+// ActionHalt with abandoned set. The step's own context being done, for
+// example after StateDeadline, doesn't start the grace period.
+func (b *BasicRunner) runStep(runCtx, ctx context.Context, index int, step Step, state StateBag) (action StepAction, abandoned bool) {
+	if b.CancelGrace <= 0 {
+		return b.runStepRecover(ctx, index, step, state), false
+	}
+
+	type result struct {
+		action StepAction
+		panic  interface{}
+	}
+
+	resultCh := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() {
+			r.panic = recover()
+			resultCh <- r
+		}()
+
+		r.action = b.runStepRecover(ctx, index, step, state)
+	}()
+
+	var r result
+	select {
+	case r = <-resultCh:
+	case <-runCtx.Done():
+		select {
+		case r = <-resultCh:
+		case <-clockOrReal(b.Clock).After(b.CancelGrace):
+			return ActionHalt, true
+		}
+	}
+
+	// Carry on a panic from the step as if it had been run here.
+	if r.panic != nil {
+		panic(r.panic)
+	}
+
+	return r.action, false
+}
+
+func (b *BasicRunner) runStepRecover(ctx context.Context, index int, step Step, state StateBag) (action StepAction) {
+	if b.RecoverPanics {
+		defer func() {
+			if r := recover(); r != nil {
+				state.Put(StateError, b.recovered(index, step, r))
+				action = ActionHalt
+			}
+		}()
+	}
+
+	return step.Run(ctx, state)
+}
+
+// recovered returns the *PanicError for a panic recovered from the step,
+// after passing it to OnPanic. It must be called from the deferred
+// function that recovered the panic, so that the stack is the panic's.
+func (b *BasicRunner) recovered(index int, step Step, r interface{}) *PanicError {
+	err := &PanicError{Value: r, Stack: debug.Stack()}
+	if b.OnPanic != nil {
+		b.OnPanic(index, step, r, err.Stack)
+	}
+
+	return err
+}
+
+// Cancel cancels the run in progress and waits for it to finish. If the
+// runner is idle, the next run is cancelled as soon as it starts instead:
+// it flags StateCancelled and runs no steps.
 func (b *BasicRunner) Cancel() {
+	b.CancelWithResult()
+}
+
+// CancelWithResult cancels the runner just like Cancel, waiting for the run
+// to finish. It returns true if a run was in progress and was cancelled,
+// or false if the runner was idle, in which case the next run is cancelled
+// instead.
+func (b *BasicRunner) CancelWithResult() bool {
+	return b.CancelWithCause(nil)
+}
+
+// CancelWithCause cancels the runner like CancelWithResult, cancelling the
+// context given to the steps with the given cause so that they can find
+// out why with context.Cause. A nil cause means ErrUserCancel.
+func (b *BasicRunner) CancelWithCause(cause error) bool {
+	doneCh, ok := b.signalCancel(cause, true)
+	if ok {
+		// Wait until we're done
+		<-doneCh
+	}
+
+	return ok
+}
+
+// CancelAsync cancels the runner like Cancel, but returns straight away
+// instead of waiting for the run to finish, so it can be called from
+// within a step without deadlocking. Done tells when the run is over.
+func (b *BasicRunner) CancelAsync() {
+	b.signalCancel(nil, true)
+}
+
+// cancelRunning cancels the run in progress like Cancel, waiting for it to
+// finish, but does nothing if the runner is idle. Runners that cancel the
+// runners they run use it, so that they never leave one to cancel its
+// next run instead.
+func (b *BasicRunner) cancelRunning() {
+	if doneCh, ok := b.signalCancel(nil, false); ok {
+		<-doneCh
+	}
+}
+
+// cancelRun cancels the run of r in progress like cancelRunning, if r is
+// a BasicRunner, or with Cancel otherwise.
+func cancelRun(r Runner) {
+	if b, ok := r.(*BasicRunner); ok {
+		b.cancelRunning()
+		return
+	}
+
+	r.Cancel()
+}
+
+// Done returns a channel that is closed once the run in progress has
+// finished, including its cleanups. If the runner is idle, the channel is
+// already closed.
+func (b *BasicRunner) Done() <-chan struct{} {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if b.doneCh == nil {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+
+	return b.doneCh
+}
+
+// signalCancel cancels the run in progress with the given cause, or
+// ErrUserCancel if it is nil, and returns the channel that is closed when
+// the run finishes. If the runner is idle, it returns false, after
+// cancelling the next run instead if pending is set.
+func (b *BasicRunner) signalCancel(cause error, pending bool) (<-chan struct{}, bool) {
+	if cause == nil {
+		cause = ErrUserCancel
+	}
+
 	b.l.Lock()
+	defer b.l.Unlock()
+
 	switch b.state {
-	case stateIdle:
-		// Not running, so Cancel is... done.
-		b.l.Unlock()
-		return
 	case stateRunning:
 		// Running, so mark that we cancelled and set the state
-		b.cancel()
-		b.state = stateCancelling
-		fallthrough
+		b.cancel(cause)
+		b.setState(stateCancelling)
+		return b.doneCh, true
 	case stateCancelling:
-		// Already cancelling, so just wait until we're done
-		ch := b.doneCh
-		b.l.Unlock()
-		<-ch
+		// Already cancelling
+		return b.doneCh, true
+	default:
+		// Not running, so cancel the next run as soon as it starts
+		if pending {
+			b.pending = cause
+		}
+		return nil, false
 	}
 }
@@ -1,8 +1,17 @@
 package multistep
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -15,6 +24,14 @@ func TestBasicRunner_ImplRunner(t *testing.T) {
 	}
 }
 
+func TestBasicRunner_ImplRunnerE(t *testing.T) {
+	var raw interface{}
+	raw = &BasicRunner{}
+	if _, ok := raw.(RunnerE); !ok {
+		t.Fatalf("BasicRunner must be a RunnerE")
+	}
+}
+
 func TestBasicRunner_Run(t *testing.T) {
 	data := new(BasicStateBag)
 	stepA := &TestStepAcc{Data: "a"}
@@ -77,6 +94,63 @@ func TestBasicRunner_Run_Halt(t *testing.T) {
 	}
 }
 
+func TestBasicRunner_Run_Abort(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b", Abort: true}
+	stepC := &TestStepAcc{Data: "c"}
+
+	var summary RunSummary
+	r := &BasicRunner{
+		Steps:      []Step{stepA, stepB, stepC},
+		OnComplete: func(s RunSummary) { summary = s },
+	}
+	result := r.RunWithResult(context.Background(), data)
+
+	expected := []string{"a", "b"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk("cleanup"); ok {
+		t.Errorf("cleanups should not run: %#v", data.Get("cleanup"))
+	}
+
+	if _, ok := data.GetOk(StateAborted); !ok {
+		t.Errorf("aborted should be in state bag")
+	}
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Errorf("halted should be in state bag")
+	}
+
+	if result.Action != ActionAbort || result.Index != 1 || result.Err != ErrAborted {
+		t.Errorf("bad result: %#v", result)
+	}
+
+	if summary.Outcome != OutcomeAborted {
+		t.Errorf("bad outcome: %s", summary.Outcome)
+	}
+}
+
+func TestBasicRunner_Run_AbortError(t *testing.T) {
+	data := new(BasicStateBag)
+	errBroken := errors.New("broken")
+	step := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		state.Put(StateError, errBroken)
+		return ActionAbort
+	}}
+
+	r := &BasicRunner{Steps: []Step{step}, ForceFullCleanup: true}
+	if err := r.RunE(context.Background(), data); !errors.Is(err, errBroken) {
+		t.Errorf("bad error: %v", err)
+	}
+
+	if r.CleanupRan(0) {
+		t.Errorf("step should not be cleaned up")
+	}
+}
+
 // confirm that can't run twice
 func TestBasicRunner_Run_Run(t *testing.T) {
 	defer func() {
@@ -108,9 +182,6 @@ func TestBasicRunner_Cancel(t *testing.T) {
 
 	r := &BasicRunner{Steps: []Step{stepA, stepB, stepInt, stepC}}
 
-	// cancelling an idle Runner is a no-op
-	r.Cancel()
-
 	go r.Run(context.Background(), data)
 
 	// Wait until we reach the sync point
@@ -169,3 +240,2836 @@ func TestBasicRunner_Cancel_Special(t *testing.T) {
 		t.Errorf("cancelled should be in state bag")
 	}
 }
+
+func TestBasicRunner_RunWithResult(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b"}
+
+	r := &BasicRunner{Steps: []Step{stepA, stepB}}
+	result := r.RunWithResult(context.Background(), data)
+
+	expected := RunResult{Action: ActionContinue, Index: 1, Name: "TestStepAcc", Completed: true}
+	if result != expected {
+		t.Errorf("unexpected result: %#v", result)
+	}
+}
+
+func TestBasicRunner_RunWithResult_Halt(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b", Halt: true}
+	stepC := &TestStepAcc{Data: "c"}
+
+	r := &BasicRunner{Steps: []Step{stepA, stepB, stepC}}
+	result := r.RunWithResult(context.Background(), data)
+
+	expected := RunResult{Action: ActionHalt, Index: 1, Name: "TestStepAcc", Completed: false, Err: ErrHalted}
+	if result != expected {
+		t.Errorf("unexpected result: %#v", result)
+	}
+}
+
+func TestBasicRunner_RunWithResult_Cancel(t *testing.T) {
+	stepOne := &TestStepInjectCancel{}
+	stepTwo := &TestStepAcc{Data: "b"}
+	r := &BasicRunner{Steps: []Step{stepOne, stepTwo}}
+
+	state := new(BasicStateBag)
+	state.Put("runner", r)
+	result := r.RunWithResult(context.Background(), state)
+
+	expected := RunResult{Action: ActionContinue, Index: 0, Name: "TestStepInjectCancel", Completed: false, Err: ErrCancelled}
+	if result != expected {
+		t.Errorf("unexpected result: %#v", result)
+	}
+}
+
+func TestBasicRunner_RunWithResult_Empty(t *testing.T) {
+	r := &BasicRunner{}
+	result := r.RunWithResult(context.Background(), new(BasicStateBag))
+
+	expected := RunResult{Action: ActionContinue, Index: -1, Completed: true}
+	if result != expected {
+		t.Errorf("unexpected result: %#v", result)
+	}
+}
+
+func TestBasicRunner_Run_Skip(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b", Skip: true}
+	stepC := &TestStepAcc{Data: "c", Skip: true}
+
+	r := &BasicRunner{Steps: []Step{stepA, stepB, stepC}}
+	result := r.RunWithResult(context.Background(), data)
+
+	// Test run data
+	expected := []string{"a", "b", "c"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	// Test cleanup data
+	expected = []string{"a"}
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	// Skipping the last step should still complete the run
+	if !result.Completed {
+		t.Errorf("run should be completed: %#v", result)
+	}
+
+	if _, ok := data.GetOk(StateHalted); ok {
+		t.Errorf("halted should not be in state bag")
+	}
+}
+
+func TestBasicRunner_Run_Observers(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b", Halt: true}
+	stepC := &TestStepAcc{Data: "c"}
+	observer := &TestObserver{}
+
+	r := &BasicRunner{
+		Steps:     []Step{stepA, stepB, stepC},
+		Observers: []StepObserver{observer},
+	}
+	r.Run(context.Background(), data)
+
+	expected := []string{"start 0", "end 0 0", "start 1", "end 1 1"}
+	if !reflect.DeepEqual(observer.Events, expected) {
+		t.Errorf("unexpected events: %#v", observer.Events)
+	}
+}
+
+func TestBasicRunner_Run_Observers_Skip(t *testing.T) {
+	data := new(BasicStateBag)
+	observer := &TestObserver{}
+
+	r := &BasicRunner{
+		Steps:         []Step{&TestStepAcc{Data: "a", Skip: true}, &TestStepAcc{Data: "b"}},
+		Observers:     []StepObserver{observer},
+		RecordTimings: true,
+	}
+	r.Run(context.Background(), data)
+
+	expected := []string{"start 0", "end 0 2", "start 1", "end 1 0"}
+	if !reflect.DeepEqual(observer.Events, expected) {
+		t.Errorf("unexpected events: %#v", observer.Events)
+	}
+
+	timings := data.Get(StateStepTimings).([]StepTiming)
+	if len(timings) != 1 || timings[0].Index != 1 {
+		t.Errorf("unexpected timings: %#v", timings)
+	}
+}
+
+func TestBasicRunner_Run_RecoverPanics(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepPanic := &FuncStep{
+		RunFunc: func(context.Context, StateBag) StepAction {
+			panic("oops")
+		},
+	}
+	stepC := &TestStepAcc{Data: "c"}
+
+	r := &BasicRunner{
+		Steps:         []Step{stepA, stepPanic, stepC},
+		RecoverPanics: true,
+	}
+	r.Run(context.Background(), data)
+
+	// Test run data
+	expected := []string{"a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	// Test cleanup data
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Errorf("halted should be in state bag")
+	}
+
+	err, ok := data.Get(StateError).(*PanicError)
+	if !ok {
+		t.Fatalf("bad error: %#v", data.Get(StateError))
+	}
+
+	if err.Value != "oops" || len(err.Stack) == 0 {
+		t.Errorf("bad error: %#v", err)
+	}
+}
+
+func TestBasicRunner_Run_OnPanic(t *testing.T) {
+	data := new(BasicStateBag)
+	stepPanic := &FuncStep{
+		RunFunc: func(context.Context, StateBag) StepAction {
+			panic("oops")
+		},
+	}
+	stepCleanupPanic := &FuncStep{
+		RunFunc: func(context.Context, StateBag) StepAction { return ActionContinue },
+		CleanupFunc: func(StateBag) {
+			panic("cleanup exploded")
+		},
+	}
+
+	var events []string
+	r := &BasicRunner{
+		Steps:         []Step{&TestStepAcc{Data: "a"}, stepCleanupPanic, stepPanic},
+		RecoverPanics: true,
+		OnPanic: func(index int, step Step, recovered interface{}, stack []byte) {
+			if len(stack) == 0 {
+				t.Errorf("%d: no stack", index)
+			}
+			if _, ok := data.GetOk("cleanup"); ok {
+				t.Errorf("%d: called after a cleanup", index)
+			}
+			events = append(events, fmt.Sprintf("%d %v", index, recovered))
+		},
+	}
+	r.Run(context.Background(), data)
+
+	expected := []string{"2 oops", "1 cleanup exploded"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("unexpected events: %#v", events)
+	}
+
+	if _, ok := data.Get(StateError).(*PanicError); !ok {
+		t.Errorf("bad error: %#v", data.Get(StateError))
+	}
+
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"a"}) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+func TestBasicRunner_Run_Deadline(t *testing.T) {
+	data := new(BasicStateBag)
+	soon := time.Now().Add(time.Hour)
+
+	var deadlines []time.Time
+	record := &FuncStep{RunFunc: func(ctx context.Context, _ StateBag) StepAction {
+		d, _ := ctx.Deadline()
+		deadlines = append(deadlines, d)
+		return ActionContinue
+	}}
+	put := func(d time.Time) Step {
+		return &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+			state.Put(StateDeadline, d)
+			return ActionContinue
+		}}
+	}
+
+	r := &BasicRunner{Steps: []Step{
+		record,
+		put(soon),
+		record,
+		put(soon.Add(time.Hour)),
+		record,
+	}}
+	r.Run(context.Background(), data)
+
+	expected := []time.Time{{}, soon, soon}
+	if !reflect.DeepEqual(deadlines, expected) {
+		t.Errorf("unexpected deadlines: %#v", deadlines)
+	}
+}
+
+func TestBasicRunner_Run_Deadline_ContextFunc(t *testing.T) {
+	data := new(BasicStateBag)
+	soon := time.Now().Add(time.Hour)
+	data.Put(StateDeadline, soon)
+
+	var deadline time.Time
+	step := &FuncStep{RunFunc: func(ctx context.Context, _ StateBag) StepAction {
+		deadline, _ = ctx.Deadline()
+		return ActionContinue
+	}}
+
+	r := &BasicRunner{
+		Steps: []Step{step},
+		ContextFunc: func(ctx context.Context, _ int, _ Step, _ StateBag) context.Context {
+			return ctx
+		},
+	}
+	r.Run(context.Background(), data)
+
+	if !deadline.Equal(soon) {
+		t.Errorf("bad deadline: %s", deadline)
+	}
+}
+
+func TestBasicRunner_Run_Deadline_CancelGrace(t *testing.T) {
+	data := new(BasicStateBag)
+	cleaned := false
+	step := &FuncStep{
+		RunFunc: func(ctx context.Context, _ StateBag) StepAction {
+			// Outlive the grace period after the deadline passes
+			<-ctx.Done()
+			time.Sleep(20 * time.Millisecond)
+			return ActionContinue
+		},
+		CleanupFunc: func(StateBag) { cleaned = true },
+	}
+
+	r := &BasicRunner{Steps: []Step{step}, CancelGrace: time.Millisecond}
+	data.Put(StateDeadline, time.Now().Add(-time.Second))
+	if result := r.RunWithResult(context.Background(), data); !result.Completed {
+		t.Fatalf("run should complete: %#v", result)
+	}
+
+	if !cleaned {
+		t.Error("step should be cleaned up")
+	}
+}
+
+func TestBasicRunner_Run_DeadlinePassed(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &FuncStep{RunFunc: func(ctx context.Context, state StateBag) StepAction {
+		<-ctx.Done()
+		return Halt(state, ctx.Err())
+	}}
+
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}, step}}
+	data.Put(StateDeadline, time.Now().Add(-time.Second))
+	if err := r.RunE(context.Background(), data); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("bad error: %v", err)
+	}
+
+	if _, ok := data.GetOk(StateCancelled); ok {
+		t.Errorf("run should not be cancelled")
+	}
+}
+
+// run with -race to check that Run and Cancel don't race on the state
+func TestBasicRunner_Cancel_Concurrent(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		data := new(BasicStateBag)
+		r := &BasicRunner{Steps: []Step{
+			&TestStepAcc{Data: "a"},
+			&TestStepWaitCancel{},
+			&TestStepAcc{Data: "b"},
+		}}
+
+		done := make(chan struct{})
+		go func() {
+			r.Run(context.Background(), data)
+			close(done)
+		}()
+
+		for {
+			r.Cancel()
+
+			select {
+			case <-done:
+			default:
+				continue
+			}
+			break
+		}
+	}
+}
+
+func TestBasicRunner_Run_RecordTimings(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b", Skip: true}
+	stepSleep := &FuncStep{
+		RunFunc: func(context.Context, StateBag) StepAction {
+			time.Sleep(10 * time.Millisecond)
+			return ActionHalt
+		},
+	}
+
+	r := &BasicRunner{
+		Steps:         []Step{stepA, stepB, stepSleep},
+		RecordTimings: true,
+	}
+	r.Run(context.Background(), data)
+
+	timings := data.Get(StateStepTimings).([]StepTiming)
+	if len(timings) != 2 {
+		t.Fatalf("bad: %#v", timings)
+	}
+
+	if timings[0].Index != 0 || timings[1].Index != 2 {
+		t.Errorf("bad: %#v", timings)
+	}
+
+	if timings[1].Duration < 10*time.Millisecond {
+		t.Errorf("bad: %#v", timings)
+	}
+}
+
+func TestBasicRunner_Run_SkipCleanupOnSuccess(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b"}
+
+	r := &BasicRunner{
+		Steps:                []Step{stepA, stepB},
+		SkipCleanupOnSuccess: true,
+	}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk("cleanup"); ok {
+		t.Errorf("cleanup should not have run")
+	}
+}
+
+func TestBasicRunner_Run_SkipCleanupOnSuccess_Halt(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b", Halt: true}
+	stepC := &TestStepAcc{Data: "c"}
+
+	r := &BasicRunner{
+		Steps:                []Step{stepA, stepB, stepC},
+		SkipCleanupOnSuccess: true,
+	}
+	r.Run(context.Background(), data)
+
+	// Only the steps that ran are cleaned up
+	expected := []string{"b", "a"}
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+func TestBasicRunner_IsRunning(t *testing.T) {
+	ch := make(chan chan bool)
+	stepInt := &TestStepSync{ch}
+	stepWait := &TestStepWaitCancel{}
+	r := &BasicRunner{Steps: []Step{stepInt, stepWait}}
+
+	if r.IsRunning() || r.IsCancelling() {
+		t.Fatal("idle runner should not be running")
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), new(BasicStateBag))
+		close(doneCh)
+	}()
+
+	// Wait until we reach the sync point
+	responseCh := <-ch
+	if !r.IsRunning() {
+		t.Fatal("runner should be running")
+	}
+
+	if r.IsCancelling() {
+		t.Fatal("runner should not be cancelling")
+	}
+
+	responseCh <- true
+	r.Cancel()
+	<-doneCh
+
+	if r.IsRunning() || r.IsCancelling() {
+		t.Fatal("finished runner should not be running")
+	}
+}
+
+func TestBasicRunner_Run_Reuse(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a", Halt: true}
+	stepB := &TestStepAcc{Data: "b"}
+
+	r := &BasicRunner{Steps: []Step{stepA, stepB}}
+	r.Run(context.Background(), data)
+
+	ResetState(data)
+	stepA.Halt = false
+	r.Run(context.Background(), data)
+
+	expected := []string{"a", "a", "b"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateHalted); ok {
+		t.Errorf("halted should not be in state bag")
+	}
+}
+
+func TestBasicRunner_Run_CleanupWithOutcome(t *testing.T) {
+	cases := []struct {
+		Halt     bool
+		Expected StepAction
+	}{
+		{false, ActionContinue},
+		{true, ActionHalt},
+	}
+
+	for _, tc := range cases {
+		data := new(BasicStateBag)
+		stepA := &TestStepOutcome{TestStepAcc: TestStepAcc{Data: "a"}}
+		stepB := &TestStepAcc{Data: "b", Halt: tc.Halt}
+
+		r := &BasicRunner{Steps: []Step{stepA, stepB}}
+		r.Run(context.Background(), data)
+
+		if !reflect.DeepEqual(stepA.Outcomes, []StepAction{tc.Expected}) {
+			t.Errorf("halt %t: unexpected outcomes: %#v", tc.Halt, stepA.Outcomes)
+		}
+
+		// The plain Cleanup is not called for steps with an outcome
+		expected := []string{"b"}
+		results := data.Get("cleanup").([]string)
+		if !reflect.DeepEqual(results, expected) {
+			t.Errorf("halt %t: unexpected result: %#v", tc.Halt, results)
+		}
+	}
+}
+
+func TestBasicRunner_Run_CleanupErrors(t *testing.T) {
+	errB := errors.New("b failed")
+	errC := errors.New("c panicked")
+
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepCleanupError{TestStepAcc: TestStepAcc{Data: "b"}, Err: errB}
+	stepC := &TestStepCleanupError{TestStepAcc: TestStepAcc{Data: "c"}, Err: errC, Panic: true}
+	stepD := &TestStepCleanupError{TestStepAcc: TestStepAcc{Data: "d"}}
+
+	r := &BasicRunner{Steps: []Step{stepA, stepB, stepC, stepD}}
+	r.Run(context.Background(), data)
+
+	// A failing or panicking cleanup doesn't stop the others
+	expected := []string{"d", "c", "b", "a"}
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	errs := data.Get(StateCleanupErrors).([]error)
+	if len(errs) != 2 {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	panicErr, ok := errs[0].(*PanicError)
+	if !ok || panicErr.Value != errC {
+		t.Errorf("bad: %#v", errs[0])
+	}
+
+	if errs[1] != errB {
+		t.Errorf("bad: %#v", errs[1])
+	}
+}
+
+func TestBasicRunner_Run_CleanupPanic(t *testing.T) {
+	data := new(BasicStateBag)
+	stepPanic := &FuncStep{
+		RunFunc: func(context.Context, StateBag) StepAction { return ActionContinue },
+		CleanupFunc: func(StateBag) {
+			panic("cleanup exploded")
+		},
+	}
+
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}, stepPanic, &TestStepAcc{Data: "c"}}}
+
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				t.Fatalf("panic should not propagate out of Run: %#v", p)
+			}
+		}()
+
+		r.Run(context.Background(), data)
+	}()
+
+	expected := []string{"c", "a"}
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	errs := data.Get(StateCleanupErrors).([]error)
+	if len(errs) != 1 {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	panicErr, ok := errs[0].(*PanicError)
+	if !ok || panicErr.Value != "cleanup exploded" || len(panicErr.Stack) == 0 {
+		t.Errorf("bad: %#v", errs[0])
+	}
+
+	for i := range r.Steps {
+		if !r.CleanupRan(i) {
+			t.Errorf("step %d should be cleaned up", i)
+		}
+	}
+}
+
+func TestBasicRunner_Run_Timeout(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepWait := &TestStepWaitCancel{}
+	stepC := &TestStepAcc{Data: "c"}
+
+	r := &BasicRunner{
+		Steps:   []Step{stepA, stepWait, stepC},
+		Timeout: 10 * time.Millisecond,
+	}
+	r.Run(context.Background(), data)
+
+	// Test run data
+	expected := []string{"a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	// Test cleanup data
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if !stepWait.CleanedUp {
+		t.Errorf("waiting step should be cleaned up")
+	}
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Errorf("cancelled should be in state bag")
+	}
+
+	// Cancelling after the timeout fired is a no-op
+	r.Cancel()
+}
+
+func TestBasicRunner_Run_Timeout_NotReached(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &BasicRunner{
+		Steps:   []Step{&TestStepAcc{Data: "a"}},
+		Timeout: time.Minute,
+	}
+	r.Run(context.Background(), data)
+
+	// Releasing the context at the end of the run must not flag a cancel
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := data.GetOk(StateCancelled); ok {
+		t.Errorf("cancelled should not be in state bag")
+	}
+}
+
+func TestBasicRunner_CancelWithResult(t *testing.T) {
+	stepWait := &TestStepWaitCancel{Started: make(chan struct{})}
+	r := &BasicRunner{Steps: []Step{stepWait}}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), new(BasicStateBag))
+		close(doneCh)
+	}()
+
+	<-stepWait.Started
+	if !r.CancelWithResult() {
+		t.Fatal("cancelling a running runner should return true")
+	}
+	<-doneCh
+
+	if r.CancelWithResult() {
+		t.Fatal("cancelling a finished runner should return false")
+	}
+}
+
+func TestBasicRunner_CancelWithCause(t *testing.T) {
+	errShutdown := errors.New("shutting down")
+
+	cases := []struct {
+		Cause    error
+		Expected error
+	}{
+		{nil, ErrUserCancel},
+		{errShutdown, errShutdown},
+	}
+
+	for _, tc := range cases {
+		causeCh := make(chan error, 1)
+		started := make(chan struct{})
+		step := &FuncStep{RunFunc: func(ctx context.Context, _ StateBag) StepAction {
+			close(started)
+			<-ctx.Done()
+			causeCh <- context.Cause(ctx)
+			return ActionContinue
+		}}
+
+		r := &BasicRunner{Steps: []Step{step}}
+		go r.Run(context.Background(), new(BasicStateBag))
+
+		<-started
+		r.CancelWithCause(tc.Cause)
+
+		if cause := <-causeCh; cause != tc.Expected {
+			t.Errorf("expected %v, got %v", tc.Expected, cause)
+		}
+	}
+}
+
+func TestBasicRunner_Run_Timeout_Cause(t *testing.T) {
+	var cause error
+	step := &FuncStep{RunFunc: func(ctx context.Context, _ StateBag) StepAction {
+		<-ctx.Done()
+		cause = context.Cause(ctx)
+		return ActionContinue
+	}}
+
+	r := &BasicRunner{Steps: []Step{step}, Timeout: 10 * time.Millisecond}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	if cause != context.DeadlineExceeded {
+		t.Errorf("bad cause: %v", cause)
+	}
+}
+
+func TestBasicRunner_Run_StartIndex(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b"}
+	stepC := &TestStepAcc{Data: "c"}
+
+	r := &BasicRunner{Steps: []Step{stepA, stepB, stepC}, StartIndex: 1}
+	result := r.RunWithResult(context.Background(), data)
+
+	// Test run data
+	expected := []string{"b", "c"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	// The skipped step is not cleaned up
+	expected = []string{"c", "b"}
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if result.Index != 2 || !result.Completed {
+		t.Errorf("unexpected result: %#v", result)
+	}
+}
+
+func TestBasicRunner_Run_StartIndex_OutOfRange(t *testing.T) {
+	for _, index := range []int{-1, 3} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%d: should have panicked", index)
+				}
+			}()
+
+			r := &BasicRunner{
+				Steps:      []Step{&TestStepAcc{}, &TestStepAcc{}},
+				StartIndex: index,
+			}
+			r.Run(context.Background(), new(BasicStateBag))
+		}()
+	}
+}
+
+func TestBasicRunner_Run_NilStep(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}, &TestStepAcc{Data: "b"}, nil}}
+
+	defer func() {
+		if p := recover(); p != "multistep: Steps[2] is nil" {
+			t.Fatalf("bad panic: %#v", p)
+		}
+
+		// The check happens before anything runs
+		if _, ok := data.GetOk("data"); ok {
+			t.Fatal("no step should run")
+		}
+
+		if r.IsRunning() {
+			t.Fatal("runner should not be left running")
+		}
+	}()
+
+	r.Run(context.Background(), data)
+}
+
+func TestBasicRunner_Validate(t *testing.T) {
+	errB := errors.New("b is misconfigured")
+
+	data := new(BasicStateBag)
+	stepA := &TestStepValidate{TestStepAcc: TestStepAcc{Data: "a"}}
+	stepB := &TestStepValidate{TestStepAcc: TestStepAcc{Data: "b"}, Err: errB}
+	stepC := &TestStepAcc{Data: "c"}
+
+	r := &BasicRunner{Steps: []Step{stepA, stepB, stepC}}
+	errs := r.Validate(data)
+
+	if len(errs) != 1 || !errors.Is(errs[0], errB) {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	if _, ok := data.GetOk("data"); ok {
+		t.Errorf("steps should not have run")
+	}
+
+	stepB.Err = nil
+	if errs := r.Validate(data); len(errs) != 0 {
+		t.Fatalf("bad: %#v", errs)
+	}
+}
+
+func TestBasicRunner_ValidateAndRun(t *testing.T) {
+	errB := errors.New("b is misconfigured")
+
+	data := new(BasicStateBag)
+	stepA := &TestStepValidate{TestStepAcc: TestStepAcc{Data: "a"}}
+	stepB := &TestStepValidate{TestStepAcc: TestStepAcc{Data: "b"}, Err: errB}
+	stepC := &TestStepAcc{Data: "c"}
+
+	r := &BasicRunner{Steps: []Step{stepA, stepB, stepC}}
+	errs := r.ValidateAndRun(context.Background(), data)
+
+	if len(errs) != 1 || !errors.Is(errs[0], errB) {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	if _, ok := data.GetOk("data"); ok {
+		t.Errorf("steps should not have run")
+	}
+
+	stepB.Err = nil
+	if errs := r.ValidateAndRun(context.Background(), data); errs != nil {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	expected := []string{"a", "b", "c"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+func TestBasicRunner_Run_CleanupOrder(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b"}
+	stepC := &TestStepAcc{Data: "c", Halt: true}
+	stepD := &TestStepAcc{Data: "d"}
+
+	r := &BasicRunner{Steps: []Step{stepA, stepB, stepC, stepD}}
+
+	if r.CleanupRan(0) {
+		t.Fatal("nothing should be cleaned up before running")
+	}
+
+	r.Run(context.Background(), data)
+
+	// Cleanups run in reverse
+	expected := []string{"c", "b", "a"}
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	// The step that was never reached got no cleanup
+	for i, expected := range []bool{true, true, true, false} {
+		if actual := r.CleanupRan(i); actual != expected {
+			t.Errorf("%d: expected %t, got %t", i, expected, actual)
+		}
+	}
+
+	if r.CleanupRan(-1) || r.CleanupRan(4) {
+		t.Errorf("out of range index should not be cleaned up")
+	}
+}
+
+func TestBasicRunner_Run_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+
+	r := &BasicRunner{
+		Steps: []Step{
+			&NamedStep{StepName: "create-instance", Step: &TestStepAcc{Data: "a"}},
+			&TestStepAcc{Data: "b", Halt: true},
+		},
+		Logger: logger,
+	}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	expected := []string{
+		`level=DEBUG msg="step start" index=0 name=create-instance`,
+		`level=DEBUG msg="step end" index=0 name=create-instance action=ActionContinue`,
+		`level=DEBUG msg="step start" index=1 name=TestStepAcc`,
+		`level=DEBUG msg="step end" index=1 name=TestStepAcc action=ActionHalt`,
+		`level=WARN msg="run halted" index=1 name=TestStepAcc`,
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected log: %#v", lines)
+	}
+}
+
+func TestBasicRunner_Run_Logger_Cancel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	r := &BasicRunner{Steps: []Step{&TestStepInjectCancel{}, &TestStepAcc{}}, Logger: logger}
+	state := new(BasicStateBag)
+	state.Put("runner", r)
+	r.Run(context.Background(), state)
+
+	if !strings.Contains(buf.String(), `level=WARN msg="run cancelled" index=0`) {
+		t.Errorf("unexpected log: %s", buf.String())
+	}
+}
+
+func TestBasicRunner_Run_NoGoroutineLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}, &TestStepAcc{Data: "b"}}}
+	for i := 0; i < 100; i++ {
+		r.Run(context.Background(), new(BasicStateBag))
+	}
+
+	for i := 0; i < 100; i++ {
+		stepWait := &TestStepWaitCancel{Started: make(chan struct{})}
+		r := &BasicRunner{Steps: []Step{stepWait}}
+
+		doneCh := make(chan struct{})
+		go func() {
+			r.Run(context.Background(), new(BasicStateBag))
+			close(doneCh)
+		}()
+
+		<-stepWait.Started
+		r.Cancel()
+		<-doneCh
+	}
+
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		r.Run(ctx, new(BasicStateBag))
+	}
+
+	// Give the goroutines that ran the runners a moment to exit
+	after := runtime.NumGoroutine()
+	for i := 0; i < 100 && after > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+		after = runtime.NumGoroutine()
+	}
+
+	if after > before {
+		t.Errorf("leaked goroutines: %d before, %d after", before, after)
+	}
+}
+
+// confirm that the context is cancelled before StateCancelled is set
+func TestBasicRunner_Cancel_ContextFirst(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		data := new(BasicStateBag)
+		started := make(chan struct{})
+		resultCh := make(chan bool, 1)
+		step := &FuncStep{RunFunc: func(ctx context.Context, state StateBag) StepAction {
+			close(started)
+			for {
+				if _, ok := state.GetOk(StateCancelled); ok {
+					resultCh <- ctx.Err() != nil
+					return ActionContinue
+				}
+
+				time.Sleep(time.Millisecond)
+			}
+		}}
+
+		r := &BasicRunner{Steps: []Step{step, &TestStepAcc{Data: "b"}}}
+		go r.Run(context.Background(), data)
+
+		<-started
+		r.Cancel()
+
+		if !<-resultCh {
+			t.Fatal("StateCancelled was set before the context was cancelled")
+		}
+
+		if _, ok := data.GetOk("data"); ok {
+			t.Fatal("next step should not have run")
+		}
+	}
+}
+
+func TestBasicRunner_Run_CleanupWithContext(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepCleanupContext{TestStepAcc: TestStepAcc{Data: "a"}}
+	stepB := &TestStepAcc{Data: "b"}
+
+	r := &BasicRunner{
+		Steps:          []Step{stepA, stepB},
+		CleanupTimeout: time.Minute,
+	}
+	r.Run(context.Background(), data)
+
+	expected := []string{"b", "a"}
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := stepA.Ctx.Deadline(); !ok {
+		t.Errorf("cleanup context should have a deadline")
+	}
+
+	if state, ok := StateBagFromContext(stepA.Ctx); !ok || state != data {
+		t.Errorf("cleanup context should carry the run's values")
+	}
+}
+
+func TestBasicRunner_Run_CleanupTimeout_Clock(t *testing.T) {
+	step := &TestStepCleanupWait{TestStepAcc: TestStepAcc{Data: "a"}}
+
+	clock := NewFakeClock(time.Now())
+	r := &BasicRunner{
+		Steps:          []Step{step},
+		CleanupTimeout: time.Minute,
+		Clock:          clock,
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), new(BasicStateBag))
+		close(doneCh)
+	}()
+
+	clock.WaitForWaiters(1)
+	clock.Advance(time.Minute)
+	<-doneCh
+
+	if step.Cause != context.DeadlineExceeded {
+		t.Fatalf("bad cause: %v", step.Cause)
+	}
+}
+
+func TestBasicRunner_Run_CleanupWithContext_Cancel(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepCleanupContext{TestStepAcc: TestStepAcc{Data: "a"}}
+	stepWait := &TestStepWaitCancel{Started: make(chan struct{})}
+
+	r := &BasicRunner{Steps: []Step{stepA, stepWait}}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	<-stepWait.Started
+	r.Cancel()
+	<-doneCh
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Fatal("cancelled should be in state bag")
+	}
+
+	// The cleanup context isn't cancelled along with the run
+	if err := stepA.Ctx.Err(); err != nil {
+		t.Errorf("cleanup context should not be cancelled: %s", err)
+	}
+
+	if _, ok := stepA.Ctx.Deadline(); ok {
+		t.Errorf("cleanup context should not have a deadline")
+	}
+}
+
+func TestBasicRunner_Run_CleanupGroups(t *testing.T) {
+	var l sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{
+		TestStepCleanupGroup{Data: "a", Group: 1, l: &l},
+		TestStepCleanupGroup{Data: "b", Group: 2, Wait: &wg, l: &l},
+		&TestStepAcc{Data: "c"},
+		TestStepCleanupGroup{Data: "d", Group: 2, Wait: &wg, l: &l},
+		&TestStepAcc{Data: "e"},
+	}}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("steps in the same group were not cleaned up concurrently")
+	}
+
+	results := data.Get("cleanup").([]string)
+	if len(results) != 5 {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+
+	// Group 2 runs first, in any order, then group 1, then the default
+	// group in reverse order
+	group2 := append([]string(nil), results[:2]...)
+	sort.Strings(group2)
+	if !reflect.DeepEqual(group2, []string{"b", "d"}) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if !reflect.DeepEqual(results[2:], []string{"a", "e", "c"}) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+func TestBasicRunner_RunNew(t *testing.T) {
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}}}
+
+	state := r.RunNew(context.Background())
+	if _, ok := state.(*BasicStateBag); !ok {
+		t.Fatalf("should default to a BasicStateBag: %#v", state)
+	}
+
+	results := state.Get("data").([]string)
+	if !reflect.DeepEqual(results, []string{"a"}) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+}
+
+func TestBasicRunner_RunNew_NewStateBag(t *testing.T) {
+	custom := new(WatchableStateBag)
+	r := &BasicRunner{
+		Steps:       []Step{&TestStepAcc{Data: "a"}},
+		NewStateBag: func() StateBag { return custom },
+	}
+
+	state := r.RunNew(context.Background())
+	if state != custom {
+		t.Fatalf("should use the state bag from NewStateBag: %#v", state)
+	}
+
+	if _, ok := custom.GetOk("data"); !ok {
+		t.Fatal("steps should have run with the new state bag")
+	}
+}
+
+func TestBasicRunner_Run_CleanupOnHalt(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{
+		TestStepCleanupOnHalt{TestStepAcc: TestStepAcc{Data: "a"}},
+		TestStepCleanupOnHalt{TestStepAcc: TestStepAcc{Data: "b", Halt: true}},
+	}}
+	r.Run(context.Background(), data)
+
+	// The step that halted opted out of its cleanup, but the step that
+	// continued is still cleaned up.
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"a"}) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+
+	if r.CleanupRan(1) {
+		t.Fatal("halted step should not be cleaned up")
+	}
+}
+
+func TestBasicRunner_Run_CleanupOnHalt_True(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{
+		TestStepCleanupOnHalt{TestStepAcc: TestStepAcc{Data: "a", Halt: true}, OnHalt: true},
+	}}
+	r.Run(context.Background(), data)
+
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"a"}) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+}
+
+func TestBasicRunner_RunSteps(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}}}
+
+	result := r.RunSteps(context.Background(), data, []Step{
+		&TestStepAcc{Data: "b"},
+		&TestStepAcc{Data: "c"},
+	})
+
+	if !result.Completed || result.Index != 1 {
+		t.Fatalf("bad result: %#v", result)
+	}
+
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, []string{"b", "c"}) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"c", "b"}) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+
+	if len(r.Steps) != 1 {
+		t.Fatalf("Steps should not be touched: %#v", r.Steps)
+	}
+}
+
+func TestBasicRunner_RunSteps_AlreadyRunning(t *testing.T) {
+	ch := make(chan chan bool)
+	r := &BasicRunner{}
+	go r.RunSteps(context.Background(), new(BasicStateBag), []Step{TestStepSync{ch}})
+
+	// Wait until the step is running
+	cont := <-ch
+	defer func() { cont <- true }()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("should panic when already running")
+		}
+	}()
+
+	r.RunSteps(context.Background(), new(BasicStateBag), []Step{&TestStepAcc{Data: "a"}})
+}
+
+func TestBasicRunner_Run_Progress(t *testing.T) {
+	progress := new(TestObserver)
+	r := &BasicRunner{
+		Steps: []Step{
+			&TestStepAcc{Data: "a"},
+			&TestStepAcc{Data: "b", Skip: true},
+			&TestStepAcc{Data: "c", Halt: true},
+			&TestStepAcc{Data: "d"},
+		},
+		Progress: progress,
+	}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	expected := []string{"progress 1/4", "progress 2/4", "progress 3/4"}
+	if !reflect.DeepEqual(progress.Events, expected) {
+		t.Fatalf("unexpected events: %#v", progress.Events)
+	}
+}
+
+func TestBasicRunner_Run_Replay(t *testing.T) {
+	data := new(BasicStateBag)
+	runs := 0
+	step := &FuncStep{
+		RunFunc: func(context.Context, StateBag) StepAction {
+			runs++
+			if runs < 3 {
+				return ActionReplay
+			}
+
+			return ActionContinue
+		},
+		CleanupFunc: func(state StateBag) {
+			TestStepAcc{Data: "replayed"}.insertData(state, "cleanup")
+		},
+	}
+
+	r := &BasicRunner{Steps: []Step{step, &TestStepAcc{Data: "b"}}}
+	result := r.RunWithResult(context.Background(), data)
+
+	if runs != 3 {
+		t.Fatalf("bad runs: %d", runs)
+	}
+
+	if !result.Completed {
+		t.Fatalf("bad result: %#v", result)
+	}
+
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"b", "replayed"}) {
+		t.Fatalf("step should be cleaned up once: %#v", results)
+	}
+}
+
+func TestBasicRunner_Run_MaxReplays(t *testing.T) {
+	cases := []struct {
+		MaxReplays int
+		Runs       int
+	}{
+		{0, DefaultMaxReplays + 1},
+		{-1, DefaultMaxReplays + 1},
+		{1, 2},
+	}
+
+	for _, tc := range cases {
+		data := new(BasicStateBag)
+		runs := 0
+		step := &FuncStep{RunFunc: func(context.Context, StateBag) StepAction {
+			runs++
+			return ActionReplay
+		}}
+
+		r := &BasicRunner{Steps: []Step{step, &TestStepAcc{Data: "b"}}, MaxReplays: tc.MaxReplays}
+		result := r.RunWithResult(context.Background(), data)
+
+		if runs != tc.Runs {
+			t.Errorf("MaxReplays %d: bad runs: %d", tc.MaxReplays, runs)
+		}
+
+		if result.Action != ActionHalt {
+			t.Errorf("MaxReplays %d: bad result: %#v", tc.MaxReplays, result)
+		}
+
+		if _, ok := data.GetOk(StateHalted); !ok {
+			t.Errorf("MaxReplays %d: halted should be in state bag", tc.MaxReplays)
+		}
+
+		if GetError(data) == nil {
+			t.Errorf("MaxReplays %d: should have an error", tc.MaxReplays)
+		}
+
+		if _, ok := data.GetOk("data"); ok {
+			t.Errorf("MaxReplays %d: next step should not run", tc.MaxReplays)
+		}
+	}
+}
+
+func TestBasicRunner_Run_CancelGrace(t *testing.T) {
+	data := new(BasicStateBag)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	stuck := &FuncStep{
+		RunFunc: func(context.Context, StateBag) StepAction {
+			close(started)
+			<-release
+			return ActionContinue
+		},
+		CleanupFunc: func(StateBag) {
+			t.Error("abandoned step should not be cleaned up")
+		},
+	}
+
+	r := &BasicRunner{
+		Steps:       []Step{&TestStepAcc{Data: "a"}, stuck},
+		CancelGrace: 10 * time.Millisecond,
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	<-started
+	r.Cancel()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("run should give up on the step after the grace period")
+	}
+
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"a"}) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Fatal("cancelled should be in state bag")
+	}
+}
+
+func TestBasicRunner_Run_CancelGrace_StepStops(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+
+	r := &BasicRunner{
+		Steps:       []Step{step},
+		CancelGrace: time.Minute,
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	<-step.Started
+	r.Cancel()
+	<-doneCh
+
+	if !step.CleanedUp {
+		t.Fatal("step that stopped within the grace period should be cleaned up")
+	}
+}
+
+func TestBasicRunner_CurrentIndex(t *testing.T) {
+	ch := make(chan chan bool)
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}, TestStepSync{ch}, &TestStepAcc{Data: "b"}}}
+
+	if r.CurrentIndex() != -1 {
+		t.Fatalf("bad index when idle: %d", r.CurrentIndex())
+	}
+
+	if r.StepCount() != 3 {
+		t.Fatalf("bad count when idle: %d", r.StepCount())
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.RunSteps(context.Background(), new(BasicStateBag), append(r.Steps, &TestStepAcc{Data: "c"}))
+		close(doneCh)
+	}()
+
+	// Wait until the sync step is running
+	cont := <-ch
+
+	if r.CurrentIndex() != 1 {
+		t.Errorf("bad index while running: %d", r.CurrentIndex())
+	}
+
+	if r.StepCount() != 4 {
+		t.Errorf("bad count while running: %d", r.StepCount())
+	}
+
+	cont <- true
+	<-doneCh
+
+	if r.CurrentIndex() != -1 {
+		t.Fatalf("bad index after run: %d", r.CurrentIndex())
+	}
+}
+
+func TestBasicRunner_Run_NextSteps(t *testing.T) {
+	data := new(BasicStateBag)
+	discover := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		TestStepAcc{Data: "discover"}.insertData(state, "data")
+		state.Put(StateNextSteps, []Step{&TestStepAcc{Data: "x"}, &TestStepAcc{Data: "y"}})
+		return ActionContinue
+	}}
+
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}, discover, &TestStepAcc{Data: "b"}}}
+	result := r.RunWithResult(context.Background(), data)
+
+	if !result.Completed || result.Index != 4 {
+		t.Fatalf("bad result: %#v", result)
+	}
+
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, []string{"a", "discover", "x", "y", "b"}) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"b", "y", "x", "a"}) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+
+	if len(r.Steps) != 3 {
+		t.Fatalf("Steps should not be modified: %#v", r.Steps)
+	}
+
+	if !r.CleanupRan(3) {
+		t.Fatal("added steps should count towards CleanupRan")
+	}
+
+	if _, ok := data.GetOk(StateNextSteps); ok {
+		t.Fatal("next steps should be removed from the state bag")
+	}
+}
+
+func TestBasicRunner_Run_NextSteps_Nil(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		state.Put(StateNextSteps, []Step{&TestStepAcc{Data: "b"}, nil})
+		return ActionContinue
+	}}
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}, step}}
+
+	defer func() {
+		if p := recover(); p != "multistep: StateNextSteps[1] added by step 1 is nil" {
+			t.Fatalf("bad panic: %#v", p)
+		}
+
+		// The added steps are not run
+		if results := data.Get("data").([]string); !reflect.DeepEqual(results, []string{"a"}) {
+			t.Fatalf("unexpected result: %#v", results)
+		}
+
+		if r.IsRunning() {
+			t.Fatal("runner should not be left running")
+		}
+	}()
+
+	r.Run(context.Background(), data)
+}
+
+func TestBasicRunner_Run_NextSteps_Halt(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		state.Put(StateNextSteps, []Step{&TestStepAcc{Data: "x"}})
+		return ActionHalt
+	}}
+
+	r := &BasicRunner{Steps: []Step{step}}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk("data"); ok {
+		t.Fatal("steps added by a halting step should not run")
+	}
+}
+
+func TestBasicRunner_RunE(t *testing.T) {
+	errOops := errors.New("oops")
+	haltErr := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		return Halt(state, errOops)
+	}}
+
+	cases := []struct {
+		Name     string
+		Steps    []Step
+		Expected error
+	}{
+		{"completed", []Step{&TestStepAcc{Data: "a"}}, nil},
+		{"halted", []Step{&TestStepAcc{Data: "a", Halt: true}}, ErrHalted},
+		{"halted with error", []Step{haltErr}, errOops},
+		{"cancelled by a step", []Step{&TestStepInjectCancel{}, &TestStepAcc{Data: "a"}}, ErrCancelled},
+	}
+
+	for _, tc := range cases {
+		data := new(BasicStateBag)
+		r := &BasicRunner{Steps: tc.Steps}
+		data.Put("runner", r)
+
+		if err := r.RunE(context.Background(), data); !errors.Is(err, tc.Expected) {
+			t.Errorf("%s: bad error: %v", tc.Name, err)
+		}
+	}
+}
+
+func TestBasicRunner_RunE_RunError(t *testing.T) {
+	errOops := errors.New("oops")
+	haltErr := &NamedStep{StepName: "broken", Step: &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		return Halt(state, errOops)
+	}}}
+
+	cases := []struct {
+		Name     string
+		Steps    []Step
+		Expected RunError
+	}{
+		{"halted", []Step{&TestStepAcc{Data: "a"}, haltErr}, RunError{OutcomeHalted, 1, "broken", errOops}},
+		{"aborted", []Step{&TestStepAcc{Data: "a", Abort: true}}, RunError{OutcomeAborted, 0, "TestStepAcc", ErrAborted}},
+		{"cancelled", []Step{&TestStepInjectCancel{}, &TestStepAcc{Data: "a"}}, RunError{OutcomeCancelled, 0, "TestStepInjectCancel", ErrCancelled}},
+	}
+
+	for _, tc := range cases {
+		data := new(BasicStateBag)
+		r := &BasicRunner{Steps: tc.Steps}
+		data.Put("runner", r)
+
+		err := r.RunE(context.Background(), data)
+
+		var runErr *RunError
+		if !errors.As(err, &runErr) {
+			t.Fatalf("%s: not a *RunError: %#v", tc.Name, err)
+		}
+		if *runErr != tc.Expected {
+			t.Errorf("%s: bad error: %#v", tc.Name, runErr)
+		}
+		if err.Error() != tc.Expected.Err.Error() {
+			t.Errorf("%s: bad message: %q", tc.Name, err.Error())
+		}
+	}
+}
+
+func TestBasicRunner_RunE_ParentCancel(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	errParent := errors.New("parent cancelled")
+	step := &FuncStep{RunFunc: func(context.Context, StateBag) StepAction {
+		cancel(errParent)
+		return ActionContinue
+	}}
+
+	r := &BasicRunner{Steps: []Step{step, &TestStepAcc{Data: "a"}}}
+	if err := r.RunE(ctx, new(BasicStateBag)); !errors.Is(err, errParent) {
+		t.Fatalf("bad error: %v", err)
+	}
+}
+
+func TestBasicRunner_RunE_Cancel(t *testing.T) {
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+	r := &BasicRunner{Steps: []Step{step}}
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- r.RunE(context.Background(), new(BasicStateBag))
+	}()
+
+	<-step.Started
+	r.Cancel()
+
+	err := <-errCh
+	if !errors.Is(err, ErrUserCancel) {
+		t.Fatalf("bad error: %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("should match context.Canceled: %v", err)
+	}
+}
+
+func TestBasicRunner_ConcurrentRunPolicy(t *testing.T) {
+	ch := make(chan chan bool)
+	data := new(BasicStateBag)
+	r := &BasicRunner{
+		Steps:               []Step{TestStepSync{ch}},
+		ConcurrentRunPolicy: ConcurrentRunIgnore,
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), new(BasicStateBag))
+		close(doneCh)
+	}()
+
+	// Wait until the step is running
+	cont := <-ch
+
+	result := r.RunSteps(context.Background(), data, []Step{&TestStepAcc{Data: "a"}})
+	if result.Err != ErrAlreadyRunning || result.Index != -1 {
+		t.Errorf("bad result: %#v", result)
+	}
+
+	if _, ok := data.GetOk("data"); ok {
+		t.Error("ignored run should not run anything")
+	}
+
+	// RunE has no outcome to report for a run that never ran
+	if err := r.RunE(context.Background(), data); err != ErrAlreadyRunning {
+		t.Errorf("bad error: %#v", err)
+	}
+
+	cont <- true
+	<-doneCh
+}
+
+func TestBasicRunner_ConcurrentRunPolicy_Wait(t *testing.T) {
+	ch := make(chan chan bool)
+	data := new(BasicStateBag)
+	r := &BasicRunner{
+		Steps:               []Step{TestStepSync{ch}},
+		ConcurrentRunPolicy: ConcurrentRunWait,
+	}
+
+	go r.Run(context.Background(), new(BasicStateBag))
+
+	// Wait until the step is running
+	cont := <-ch
+
+	resultCh := make(chan RunResult)
+	go func() {
+		resultCh <- r.RunSteps(context.Background(), data, []Step{&TestStepAcc{Data: "a"}})
+	}()
+
+	select {
+	case <-resultCh:
+		t.Fatal("should wait for the run in progress")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cont <- true
+
+	result := <-resultCh
+	if !result.Completed {
+		t.Fatalf("bad result: %#v", result)
+	}
+
+	if _, ok := data.GetOk("data"); !ok {
+		t.Fatal("second run should run once the first is done")
+	}
+}
+
+func TestBasicRunner_Run_CancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}, &TestStepAcc{Data: "b"}}}
+	result := r.RunWithResult(ctx, data)
+
+	if result.Index != -1 || result.Completed || result.Err != context.Canceled {
+		t.Fatalf("bad result: %#v", result)
+	}
+
+	if _, ok := data.GetOk("data"); ok {
+		t.Fatal("no step should run with a cancelled context")
+	}
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Fatal("cancelled should be in state bag")
+	}
+}
+
+func TestBasicRunner_Run_RecordCleanupTimings(t *testing.T) {
+	for _, halt := range []bool{false, true} {
+		data := new(BasicStateBag)
+		slow := &FuncStep{
+			RunFunc: func(context.Context, StateBag) StepAction { return ActionContinue },
+			CleanupFunc: func(StateBag) {
+				time.Sleep(10 * time.Millisecond)
+			},
+		}
+
+		r := &BasicRunner{
+			Steps: []Step{
+				slow,
+				&TestStepAcc{Data: "b", Skip: true},
+				&TestStepAcc{Data: "c", Halt: halt},
+			},
+			RecordCleanupTimings: true,
+		}
+		r.Run(context.Background(), data)
+
+		timings := data.Get(StateCleanupTimings).([]StepTiming)
+		if len(timings) != 2 || timings[0].Index != 2 || timings[1].Index != 0 {
+			t.Fatalf("halt=%t: bad timings: %#v", halt, timings)
+		}
+
+		if timings[1].Duration < 10*time.Millisecond {
+			t.Errorf("halt=%t: bad duration: %s", halt, timings[1].Duration)
+		}
+
+		total := data.Get(StateCleanupDuration).(time.Duration)
+		if total < timings[1].Duration {
+			t.Errorf("halt=%t: bad total: %s", halt, total)
+		}
+	}
+}
+
+func TestBasicRunner_Run_RecordCleanupTimings_Off(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}}}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk(StateCleanupTimings); ok {
+		t.Fatal("cleanup timings should not be recorded")
+	}
+}
+
+func TestBasicRunner_Run_ContextFunc(t *testing.T) {
+	type key struct{}
+
+	data := new(BasicStateBag)
+	var seen []interface{}
+	step := &FuncStep{RunFunc: func(ctx context.Context, _ StateBag) StepAction {
+		seen = append(seen, ctx.Value(key{}))
+		return ActionContinue
+	}}
+
+	r := &BasicRunner{
+		Steps: []Step{step, step},
+		ContextFunc: func(ctx context.Context, index int, _ Step, _ StateBag) context.Context {
+			return context.WithValue(ctx, key{}, index)
+		},
+	}
+	r.Run(context.Background(), data)
+
+	if !reflect.DeepEqual(seen, []interface{}{0, 1}) {
+		t.Fatalf("bad: %#v", seen)
+	}
+}
+
+func TestBasicRunner_Run_ContextFunc_Cancel(t *testing.T) {
+	type key struct{}
+
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+	r := &BasicRunner{
+		Steps: []Step{step},
+		ContextFunc: func(ctx context.Context, _ int, _ Step, _ StateBag) context.Context {
+			return context.WithValue(ctx, key{}, true)
+		},
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), new(BasicStateBag))
+		close(doneCh)
+	}()
+
+	<-step.Started
+	r.Cancel()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("cancel should reach the derived context")
+	}
+}
+
+func TestBasicRunner_Run_ForceFullCleanup(t *testing.T) {
+	data := new(BasicStateBag)
+
+	var cleanups []string
+	recordStep := func(name string, action StepAction) Step {
+		return &FuncStep{
+			RunFunc: func(context.Context, StateBag) StepAction { return action },
+			CleanupFunc: func(state StateBag) {
+				cleanups = append(cleanups, fmt.Sprintf("%s %v", name, state.Get(StateStepRan)))
+			},
+		}
+	}
+
+	r := &BasicRunner{
+		Steps: []Step{
+			recordStep("a", ActionContinue),
+			recordStep("b", ActionSkip),
+			recordStep("c", ActionHalt),
+			recordStep("d", ActionContinue),
+		},
+		ForceFullCleanup: true,
+	}
+	r.Run(context.Background(), data)
+
+	expected := []string{"d false", "c true", "b true", "a true"}
+	if !reflect.DeepEqual(cleanups, expected) {
+		t.Fatalf("unexpected cleanups: %#v", cleanups)
+	}
+
+	for i := range r.Steps {
+		if !r.CleanupRan(i) {
+			t.Errorf("step %d should be cleaned up", i)
+		}
+	}
+}
+
+func TestBasicRunner_Run_ForceFullCleanup_Completed(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &BasicRunner{
+		Steps:            []Step{&TestStepAcc{Data: "a"}, &TestStepAcc{Data: "b", Skip: true}},
+		ForceFullCleanup: true,
+	}
+	r.Run(context.Background(), data)
+
+	// Only a halt or cancel cleans up everything
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"a"}) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateStepRan); ok {
+		t.Fatal("ran flag should only be set by a full cleanup")
+	}
+}
+
+func TestBasicRunner_Run_WeightedProgress(t *testing.T) {
+	progress := new(TestWeightedProgress)
+	r := &BasicRunner{
+		Steps: []Step{
+			&TestStepAcc{Data: "a"},
+			TestStepWeighted{TestStepAcc: TestStepAcc{Data: "build"}, W: 10},
+			&TestStepAcc{Data: "c", Halt: true},
+			&TestStepAcc{Data: "d"},
+		},
+		Progress: progress,
+	}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	// The halted run reports the weight it reached
+	expected := []string{
+		"progress 1/4", "weighted 1/13",
+		"progress 2/4", "weighted 11/13",
+		"progress 3/4", "weighted 12/13",
+	}
+	if !reflect.DeepEqual(progress.Events, expected) {
+		t.Fatalf("unexpected events: %#v", progress.Events)
+	}
+}
+
+func TestBasicRunner_Run_Timeout_Clock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	data := new(BasicStateBag)
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+
+	r := &BasicRunner{
+		Steps:   []Step{step, &TestStepAcc{Data: "b"}},
+		Timeout: time.Hour,
+		Clock:   clock,
+	}
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- r.RunE(context.Background(), data)
+	}()
+
+	<-step.Started
+	clock.WaitForWaiters(1)
+	clock.Advance(time.Hour)
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("bad error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("run should time out on the fake clock")
+	}
+
+	if _, ok := data.GetOk("data"); ok {
+		t.Fatal("next step should not run")
+	}
+}
+
+func TestBasicRunner_Run_OnComplete(t *testing.T) {
+	cases := []struct {
+		name     string
+		steps    []Step
+		expected RunSummary
+	}{
+		{
+			"completed",
+			[]Step{&TestStepAcc{Data: "a"}, &TestStepAcc{Data: "b", Skip: true}},
+			RunSummary{Outcome: OutcomeCompleted, StepsRun: 2, StepsSkipped: 1},
+		},
+		{
+			"halted",
+			[]Step{&TestStepAcc{Data: "a", Halt: true}, &TestStepAcc{Data: "b"}},
+			RunSummary{Outcome: OutcomeHalted, StepsRun: 1, Err: ErrHalted},
+		},
+		{
+			"cancelled",
+			[]Step{&TestStepAcc{Data: "a"}, TestStepInjectCancel{}, &TestStepAcc{Data: "c"}},
+			RunSummary{Outcome: OutcomeCancelled, StepsRun: 2, Err: ErrCancelled},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := new(BasicStateBag)
+			var summaries []RunSummary
+			r := &BasicRunner{
+				Steps: tc.steps,
+				OnComplete: func(s RunSummary) {
+					// Cleanups have all finished by now
+					if _, ok := data.GetOk("cleanup"); !ok {
+						t.Error("cleanups should run first")
+					}
+					summaries = append(summaries, s)
+				},
+			}
+			data.Put("runner", r)
+			r.Run(context.Background(), data)
+
+			if len(summaries) != 1 {
+				t.Fatalf("OnComplete should be called once, got %d", len(summaries))
+			}
+			summary := summaries[0]
+			summary.Duration = 0
+			if summary != tc.expected {
+				t.Fatalf("unexpected summary: %#v", summary)
+			}
+		})
+	}
+}
+
+func TestBasicRunner_Run_OnComplete_Panic(t *testing.T) {
+	var summary RunSummary
+	r := &BasicRunner{
+		Steps: []Step{
+			&TestStepAcc{Data: "a"},
+			&FuncStep{RunFunc: func(context.Context, StateBag) StepAction { panic("boom") }},
+		},
+		RecoverPanics: true,
+		OnComplete:    func(s RunSummary) { summary = s },
+	}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	if summary.Outcome != OutcomeHalted {
+		t.Fatalf("bad outcome: %v", summary.Outcome)
+	}
+	if _, ok := summary.Err.(*PanicError); !ok {
+		t.Fatalf("bad error: %#v", summary.Err)
+	}
+}
+
+func TestBasicRunner_Run_OnComplete_CancelDuringCleanup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var summary RunSummary
+	r := &BasicRunner{
+		Steps: []Step{
+			&FuncStep{
+				RunFunc: func(context.Context, StateBag) StepAction { return ActionContinue },
+				CleanupFunc: func(StateBag) {
+					// Give a watcher that's still running time to notice
+					cancel()
+					time.Sleep(10 * time.Millisecond)
+				},
+			},
+		},
+		OnComplete: func(s RunSummary) { summary = s },
+	}
+	data := new(BasicStateBag)
+	r.Run(ctx, data)
+
+	if summary.Outcome != OutcomeCompleted {
+		t.Fatalf("bad outcome: %v", summary.Outcome)
+	}
+	if _, ok := data.GetOk(StateCancelled); ok {
+		t.Fatal("should not be cancelled")
+	}
+}
+
+func TestBasicRunner_Run_OnComplete_UnrecoveredPanic(t *testing.T) {
+	var summary RunSummary
+	r := &BasicRunner{
+		Steps: []Step{
+			&FuncStep{RunFunc: func(context.Context, StateBag) StepAction { panic("boom") }},
+		},
+		OnComplete: func(s RunSummary) { summary = s },
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("panic should carry on: %#v", p)
+			}
+		}()
+
+		r.Run(context.Background(), new(BasicStateBag))
+	}()
+
+	if summary.Outcome != OutcomeHalted {
+		t.Fatalf("bad outcome: %v", summary.Outcome)
+	}
+	if err, ok := summary.Err.(*PanicError); !ok || err.Value != "boom" {
+		t.Fatalf("bad error: %#v", summary.Err)
+	}
+}
+
+func TestBasicRunner_Run_OnComplete_Duration(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	var summary RunSummary
+	r := &BasicRunner{
+		Steps: []Step{&FuncStep{RunFunc: func(context.Context, StateBag) StepAction {
+			clock.Advance(time.Minute)
+			return ActionContinue
+		}}},
+		Clock:      clock,
+		OnComplete: func(s RunSummary) { summary = s },
+	}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	if summary.Duration != time.Minute {
+		t.Fatalf("bad duration: %s", summary.Duration)
+	}
+}
+
+func TestBasicRunner_CancelAsync(t *testing.T) {
+	data := new(BasicStateBag)
+	r := new(BasicRunner)
+	stepCancel := &FuncStep{RunFunc: func(context.Context, StateBag) StepAction {
+		// Cancel would wait for this very step, and never return
+		r.CancelAsync()
+		return ActionContinue
+	}}
+	r.Steps = []Step{&TestStepAcc{Data: "a"}, stepCancel, &TestStepAcc{Data: "c"}}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Fatal("run should be cancelled")
+	}
+	if results := data.Get("data").([]string); !reflect.DeepEqual(results, []string{"a"}) {
+		t.Fatalf("unexpected results: %#v", results)
+	}
+}
+
+func TestBasicRunner_Done(t *testing.T) {
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+	r := &BasicRunner{Steps: []Step{step}}
+
+	select {
+	case <-r.Done():
+	default:
+		t.Fatal("an idle runner should be done")
+	}
+
+	go r.Run(context.Background(), new(BasicStateBag))
+	<-step.Started
+
+	done := r.Done()
+	select {
+	case <-done:
+		t.Fatal("should not be done while running")
+	default:
+	}
+
+	r.CancelAsync()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run should finish after CancelAsync")
+	}
+
+	if !r.CleanupRan(0) {
+		t.Error("the step should be cleaned up once done")
+	}
+}
+
+func TestBasicRunner_CancelRunning(t *testing.T) {
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+	r := &BasicRunner{Steps: []Step{step}}
+
+	doneCh := make(chan struct{})
+	data := new(BasicStateBag)
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	<-step.Started
+	cancelRun(r)
+	<-doneCh
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Fatal("run should be cancelled")
+	}
+
+	// As the runner of a SequenceRunner that just finished, an idle
+	// cancel must not cancel the next run
+	r = &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}}}
+	cancelRun(r)
+	data = new(BasicStateBag)
+	r.Run(context.Background(), data)
+	if _, ok := data.GetOk(StateCancelled); ok {
+		t.Fatal("next run should not be cancelled")
+	}
+}
+
+func TestBasicRunner_Cancel_BeforeRun(t *testing.T) {
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}}}
+
+	// An idle cancel has nothing to wait for, but cancels the next run
+	if r.CancelWithResult() {
+		t.Fatal("idle cancel should not report a cancelled run")
+	}
+
+	data := new(BasicStateBag)
+	result := r.RunWithResult(context.Background(), data)
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Fatal("run should be cancelled")
+	}
+	if _, ok := data.GetOk("data"); ok {
+		t.Fatal("no step should run")
+	}
+	if result.Err != ErrUserCancel {
+		t.Fatalf("bad error: %v", result.Err)
+	}
+
+	// Even with no step to run, the run is cancelled
+	empty := &BasicRunner{}
+	empty.Cancel()
+	if err := empty.RunE(context.Background(), new(BasicStateBag)); !errors.Is(err, ErrUserCancel) {
+		t.Fatalf("bad error for an empty run: %v", err)
+	}
+
+	r.StartIndex = len(r.Steps)
+	r.Cancel()
+	if err := r.RunE(context.Background(), new(BasicStateBag)); !errors.Is(err, ErrUserCancel) {
+		t.Fatalf("bad error past the last step: %v", err)
+	}
+	r.StartIndex = 0
+
+	// The pending cancel is used up by that run
+	data = new(BasicStateBag)
+	r.Run(context.Background(), data)
+	if _, ok := data.GetOk(StateCancelled); ok {
+		t.Fatal("second run should not be cancelled")
+	}
+	if results := data.Get("data").([]string); !reflect.DeepEqual(results, []string{"a"}) {
+		t.Fatalf("unexpected results: %#v", results)
+	}
+}
+
+func TestBasicRunner_Run_RunID(t *testing.T) {
+	var seen string
+	r := &BasicRunner{Steps: []Step{&FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		seen = RunID(state)
+		return ActionContinue
+	}}}}
+
+	data := new(BasicStateBag)
+	r.Run(context.Background(), data)
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(seen) {
+		t.Fatalf("bad run ID: %q", seen)
+	}
+
+	// Each run gets its own ID
+	first := RunID(data)
+	r.Run(context.Background(), new(BasicStateBag))
+	if seen == first {
+		t.Fatal("run IDs should differ")
+	}
+
+	// An ID that is already there is kept
+	data = new(BasicStateBag)
+	data.Put(StateRunID, "mine")
+	r.Run(context.Background(), data)
+	if seen != "mine" {
+		t.Fatalf("bad run ID: %q", seen)
+	}
+}
+
+func TestBasicRunner_Run_ContinueOnHalt(t *testing.T) {
+	errA := errors.New("a failed")
+	data := new(BasicStateBag)
+	r := &BasicRunner{
+		Steps: []Step{
+			&FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+				return Halt(state, errA)
+			}},
+			&TestStepAcc{Data: "b"},
+			&TestStepAcc{Data: "c", Halt: true},
+			&TestStepAcc{Data: "d"},
+		},
+		ContinueOnHalt: true,
+	}
+	result := r.RunWithResult(context.Background(), data)
+
+	// Every step runs and is cleaned up
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, []string{"b", "c", "d"}) {
+		t.Fatalf("unexpected results: %#v", results)
+	}
+	cleanups := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(cleanups, []string{"d", "c", "b"}) {
+		t.Fatalf("unexpected cleanups: %#v", cleanups)
+	}
+
+	expected := []StepFailure{
+		{Index: 0, Name: "FuncStep", Err: errA},
+		{Index: 2, Name: "TestStepAcc", Err: ErrHalted},
+	}
+	if failures := data.Get(StateFailures); !reflect.DeepEqual(failures, expected) {
+		t.Fatalf("unexpected failures: %#v", failures)
+	}
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Fatal("run should be halted")
+	}
+	if result.Completed || result.Action != ActionHalt || result.Index != 2 {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+	if !errors.Is(result.Err, errA) || !errors.Is(result.Err, ErrHalted) {
+		t.Fatalf("error should join the failures: %v", result.Err)
+	}
+	if GetError(data) != result.Err {
+		t.Fatalf("bad state error: %v", GetError(data))
+	}
+}
+
+func TestBasicRunner_Run_ContinueOnHalt_NoFailures(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &BasicRunner{
+		Steps:          []Step{&TestStepAcc{Data: "a"}, &TestStepAcc{Data: "b"}},
+		ContinueOnHalt: true,
+	}
+	result := r.RunWithResult(context.Background(), data)
+
+	if !result.Completed || result.Err != nil {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+	if _, ok := data.GetOk(StateFailures); ok {
+		t.Fatal("there should be no failures")
+	}
+	if _, ok := data.GetOk(StateHalted); ok {
+		t.Fatal("run should not be halted")
+	}
+}
+
+func TestBasicRunner_Run_CleanupForward(t *testing.T) {
+	for _, halt := range []bool{false, true} {
+		data := new(BasicStateBag)
+		r := &BasicRunner{
+			Steps: []Step{
+				&TestStepAcc{Data: "a"},
+				&TestStepAcc{Data: "b", Skip: true},
+				&TestStepAcc{Data: "c"},
+				&TestStepAcc{Data: "d", Halt: halt},
+				&TestStepAcc{Data: "e"},
+			},
+			CleanupOrder: CleanupForward,
+		}
+		r.Run(context.Background(), data)
+
+		// Only the steps that ran are cleaned up, in the order they ran
+		expected := []string{"a", "c", "d", "e"}
+		if halt {
+			expected = []string{"a", "c", "d"}
+		}
+		results := data.Get("cleanup").([]string)
+		if !reflect.DeepEqual(results, expected) {
+			t.Fatalf("halt=%t: unexpected cleanups: %#v", halt, results)
+		}
+	}
+}
+
+func TestBasicRunner_Run_CleanupForward_Errors(t *testing.T) {
+	errA := errors.New("a")
+	errB := errors.New("b")
+	data := new(BasicStateBag)
+	r := &BasicRunner{
+		Steps: []Step{
+			&TestStepCleanupError{Err: errA},
+			&TestStepCleanupError{Err: errB},
+		},
+		CleanupOrder: CleanupForward,
+	}
+	r.Run(context.Background(), data)
+
+	// The errors are in the order the cleanups ran
+	errs := data.Get(StateCleanupErrors).([]error)
+	if !reflect.DeepEqual(errs, []error{errA, errB}) {
+		t.Fatalf("unexpected errors: %#v", errs)
+	}
+}
+
+func TestBasicRunner_Run_Metadata(t *testing.T) {
+	var seen map[string]interface{}
+	r := &BasicRunner{
+		Steps: []Step{&FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+			seen = Metadata(state)
+			return ActionContinue
+		}}},
+		Metadata: map[string]interface{}{"env": "staging"},
+	}
+
+	data := new(BasicStateBag)
+	r.Run(context.Background(), data)
+	if !reflect.DeepEqual(seen, map[string]interface{}{"env": "staging"}) {
+		t.Fatalf("unexpected metadata: %#v", seen)
+	}
+
+	// The bag holds a copy
+	seen["env"] = "prod"
+	if r.Metadata["env"] != "staging" {
+		t.Fatal("metadata should be copied")
+	}
+
+	// Without metadata nothing is put
+	r.Metadata = nil
+	data = new(BasicStateBag)
+	r.Run(context.Background(), data)
+	if _, ok := data.GetOk(StateMetadata); ok {
+		t.Fatal("there should be no metadata")
+	}
+}
+
+func TestBasicRunner_Run_StepResults(t *testing.T) {
+	errC := errors.New("c failed")
+	clock := NewFakeClock(time.Now())
+	data := new(BasicStateBag)
+	r := &BasicRunner{
+		Steps: []Step{
+			&FuncStep{RunFunc: func(context.Context, StateBag) StepAction {
+				clock.Advance(time.Second)
+				return ActionContinue
+			}},
+			&TestStepAcc{Data: "b", Skip: true},
+			&FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+				return Halt(state, errC)
+			}},
+			&TestStepAcc{Data: "d"},
+		},
+		Clock: clock,
+	}
+	r.Run(context.Background(), data)
+
+	expected := []StepResult{
+		{Index: 0, Name: "FuncStep", Action: ActionContinue, Duration: time.Second},
+		{Index: 1, Name: "TestStepAcc", Action: ActionSkip},
+		{Index: 2, Name: "FuncStep", Action: ActionHalt, Err: errC},
+	}
+	if results := data.Get(StateStepResults); !reflect.DeepEqual(results, expected) {
+		t.Fatalf("unexpected results: %#v", results)
+	}
+}
+
+func TestBasicRunner_Run_CleanupDeadline(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepCleanupContext{TestStepAcc: TestStepAcc{Data: "a"}}
+	slow := &FuncStep{
+		RunFunc:     func(context.Context, StateBag) StepAction { return ActionContinue },
+		CleanupFunc: func(StateBag) { time.Sleep(50 * time.Millisecond) },
+	}
+
+	r := &BasicRunner{
+		Steps:           []Step{stepA, &TestStepAcc{Data: "b"}, slow, &TestStepAcc{Data: "d"}},
+		CleanupDeadline: 10 * time.Millisecond,
+	}
+	r.Run(context.Background(), data)
+
+	// The cleanups after the deadline still run, with an expired context
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"d", "b", "a"}) {
+		t.Fatalf("unexpected cleanups: %#v", results)
+	}
+	if stepA.Ctx.Err() != context.DeadlineExceeded {
+		t.Fatalf("bad context error: %v", stepA.Ctx.Err())
+	}
+
+	overdue := data.Get(StateCleanupOverdue)
+	if !reflect.DeepEqual(overdue, []int{2, 1, 0}) {
+		t.Fatalf("unexpected overdue cleanups: %#v", overdue)
+	}
+}
+
+func TestBasicRunner_Run_CleanupDeadline_InTime(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TestStepCleanupContext{TestStepAcc: TestStepAcc{Data: "a"}}
+	r := &BasicRunner{
+		Steps:           []Step{step},
+		CleanupDeadline: time.Minute,
+	}
+	r.Run(context.Background(), data)
+
+	if _, ok := step.Ctx.Deadline(); !ok {
+		t.Fatal("cleanup context should have the deadline")
+	}
+	if _, ok := data.GetOk(StateCleanupOverdue); ok {
+		t.Fatal("no cleanup should be overdue")
+	}
+}
+
+func TestBasicRunner_Run_Uninterruptible(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TestStepUninterruptible{
+		TestStepAcc: TestStepAcc{Data: "a"},
+		Started:     make(chan struct{}),
+		Release:     make(chan struct{}),
+	}
+
+	r := &BasicRunner{
+		Steps:       []Step{step, &TestStepAcc{Data: "b"}},
+		CancelGrace: time.Millisecond,
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	<-step.Started
+	go r.Cancel()
+	for !r.IsCancelling() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The cancel waits for the step, even past the grace period
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-doneCh:
+		t.Fatal("run should wait for the uninterruptible step")
+	default:
+	}
+
+	close(step.Release)
+	<-doneCh
+
+	if step.CtxErr != nil {
+		t.Fatalf("step context should not be cancelled: %v", step.CtxErr)
+	}
+
+	// The step finished and was cleaned up, and the run stopped after it
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, []string{"a"}) {
+		t.Fatalf("unexpected results: %#v", results)
+	}
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"a"}) {
+		t.Fatalf("unexpected cleanups: %#v", results)
+	}
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Fatal("run should be cancelled")
+	}
+}
+
+func TestBasicRunner_Run_UnknownAction(t *testing.T) {
+	unknown := &FuncStep{RunFunc: func(context.Context, StateBag) StepAction { return StepAction(42) }}
+
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{unknown, &TestStepAcc{Data: "b"}}}
+	err := r.RunE(context.Background(), data)
+
+	if err == nil || err.Error() != "multistep: step 0 (FuncStep) returned unknown action StepAction(42)" {
+		t.Fatalf("bad error: %v", err)
+	}
+	if _, ok := data.GetOk("data"); ok {
+		t.Fatal("next step should not run")
+	}
+}
+
+func TestBasicRunner_Run_OnUnknownAction(t *testing.T) {
+	unknown := &FuncStep{RunFunc: func(context.Context, StateBag) StepAction { return StepAction(42) }}
+
+	var seen []StepAction
+	data := new(BasicStateBag)
+	r := &BasicRunner{
+		Steps: []Step{unknown, &TestStepAcc{Data: "b"}},
+		OnUnknownAction: func(a StepAction) StepAction {
+			seen = append(seen, a)
+			return ActionContinue
+		},
+	}
+	r.Run(context.Background(), data)
+
+	if !reflect.DeepEqual(seen, []StepAction{42}) {
+		t.Fatalf("unexpected actions: %#v", seen)
+	}
+	if results := data.Get("data").([]string); !reflect.DeepEqual(results, []string{"b"}) {
+		t.Fatalf("unexpected results: %#v", results)
+	}
+
+	// Mapping to another unknown action still halts
+	r.OnUnknownAction = func(StepAction) StepAction { return StepAction(43) }
+	data = new(BasicStateBag)
+	r.Run(context.Background(), data)
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Fatal("run should halt")
+	}
+}
+
+func TestBasicRunner_RunContext(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+	next := &TestStepAcc{Data: "b"}
+
+	var summary RunSummary
+	r := &BasicRunner{
+		Steps:      []Step{step, next},
+		OnComplete: func(s RunSummary) { summary = s },
+	}
+
+	cancel, doneCh := r.RunContext(context.Background(), data)
+	<-step.Started
+	cancel()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("run should be cancelled")
+	}
+
+	// The same as Cancel
+	if !step.CleanedUp {
+		t.Fatal("step should be cleaned up")
+	}
+	if _, ok := data.GetOk("data"); ok {
+		t.Fatal("next step should not run")
+	}
+	if summary.Outcome != OutcomeCancelled || summary.Err != ErrUserCancel {
+		t.Fatalf("unexpected summary: %#v", summary)
+	}
+
+	// Cancelling again, after the run, does nothing to the next run
+	cancel()
+	r.Steps = []Step{next}
+	cancel, doneCh = r.RunContext(context.Background(), new(BasicStateBag))
+	<-doneCh
+	cancel()
+	if summary.Outcome != OutcomeCompleted {
+		t.Fatalf("unexpected outcome: %v", summary.Outcome)
+	}
+}
+
+func TestBasicRunner_RunContext_Concurrent(t *testing.T) {
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+	r := &BasicRunner{Steps: []Step{step}}
+
+	var wg sync.WaitGroup
+	panics := make(chan interface{}, 2)
+	cancels := make(chan context.CancelFunc, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if p := recover(); p != nil {
+					panics <- p
+				}
+			}()
+
+			cancel, _ := r.RunContext(context.Background(), new(BasicStateBag))
+			cancels <- cancel
+		}()
+	}
+	wg.Wait()
+
+	// Exactly one caller started the run; the other panicked itself
+	if len(panics) != 1 || len(cancels) != 1 {
+		t.Fatalf("bad: %d panics, %d runs", len(panics), len(cancels))
+	}
+
+	<-step.Started
+	r.Cancel()
+	(<-cancels)()
+}
+
+func TestBasicRunner_RanSteps(t *testing.T) {
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+	r := &BasicRunner{Steps: []Step{
+		&TestStepAcc{Data: "a"},
+		&TestStepAcc{Data: "b", Skip: true},
+		step,
+		&TestStepAcc{Data: "d"},
+	}}
+
+	if ran := r.RanSteps(); ran != nil {
+		t.Fatalf("a runner that never ran should have no steps: %#v", ran)
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), new(BasicStateBag))
+		close(doneCh)
+	}()
+
+	<-step.Started
+	r.Cancel()
+	<-doneCh
+
+	expected := []bool{true, true, true, false}
+	if ran := r.RanSteps(); !reflect.DeepEqual(ran, expected) {
+		t.Fatalf("unexpected ran steps: %#v", ran)
+	}
+
+	// Each run starts afresh
+	r.Steps = r.Steps[:2]
+	r.StartIndex = 1
+	r.Run(context.Background(), new(BasicStateBag))
+	if ran := r.RanSteps(); !reflect.DeepEqual(ran, []bool{false, true}) {
+		t.Fatalf("unexpected ran steps: %#v", ran)
+	}
+}
+
+func TestBasicRunner_Run_CustomKeys(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &BasicRunner{
+		Steps:        []Step{&TestStepAcc{Data: "a", Halt: true}},
+		CancelledKey: "multistep.cancelled",
+		HaltedKey:    "multistep.halted",
+	}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk("multistep.halted"); !ok {
+		t.Fatal("halted should be under the custom key")
+	}
+	if _, ok := data.GetOk(StateHalted); ok {
+		t.Fatal("the default key should not be set")
+	}
+
+	// A cancel uses the custom key too, while the default key is left to
+	// the caller
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+	var summary RunSummary
+	r.Steps = []Step{step, &TestStepAcc{Data: "b"}}
+	r.OnComplete = func(s RunSummary) { summary = s }
+
+	data = new(BasicStateBag)
+	data.Put(StateCancelled, "unrelated")
+	go func() {
+		<-step.Started
+		r.Cancel()
+	}()
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk("multistep.cancelled"); !ok {
+		t.Fatal("cancelled should be under the custom key")
+	}
+	if v := data.Get(StateCancelled); v != "unrelated" {
+		t.Fatalf("the default key should be untouched: %#v", v)
+	}
+	if _, ok := data.GetOk("data"); ok {
+		t.Fatal("next step should not run")
+	}
+	if summary.Outcome != OutcomeCancelled {
+		t.Fatalf("bad outcome: %v", summary.Outcome)
+	}
+}
+
+func TestBasicRunner_Run_CleanupRetry(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	data := new(BasicStateBag)
+	step := &TestStepCleanupRetry{TestStepAcc: TestStepAcc{Data: "a"}, Errs: []error{errFlaky, errFlaky}}
+
+	var backoffs []int
+	r := &BasicRunner{
+		Steps: []Step{step},
+		CleanupRetry: CleanupRetry{
+			MaxAttempts: 3,
+			Backoff: func(attempt int) time.Duration {
+				backoffs = append(backoffs, attempt)
+				return time.Millisecond
+			},
+		},
+		CleanupTimeout: time.Minute,
+	}
+	r.Run(context.Background(), data)
+
+	if step.Attempts != 3 {
+		t.Fatalf("bad attempts: %d", step.Attempts)
+	}
+	if !reflect.DeepEqual(backoffs, []int{1, 2}) {
+		t.Fatalf("unexpected backoffs: %#v", backoffs)
+	}
+	if _, ok := step.Ctx.Deadline(); !ok {
+		t.Fatal("each attempt should have the cleanup timeout")
+	}
+	if _, ok := data.GetOk(StateCleanupErrors); ok {
+		t.Fatal("the cleanup succeeded in the end")
+	}
+}
+
+func TestBasicRunner_Run_CleanupRetry_GiveUp(t *testing.T) {
+	errA := errors.New("a")
+	errB := errors.New("b")
+	data := new(BasicStateBag)
+	step := &TestStepCleanupRetry{TestStepAcc: TestStepAcc{Data: "a"}, Errs: []error{errA, errB, errB}}
+
+	r := &BasicRunner{
+		Steps:        []Step{step},
+		CleanupRetry: CleanupRetry{MaxAttempts: 2},
+	}
+	r.Run(context.Background(), data)
+
+	// The error of the last attempt is the one recorded
+	if step.Attempts != 2 {
+		t.Fatalf("bad attempts: %d", step.Attempts)
+	}
+	if errs := data.Get(StateCleanupErrors); !reflect.DeepEqual(errs, []error{errB}) {
+		t.Fatalf("unexpected errors: %#v", errs)
+	}
+}
+
+func TestBasicRunner_Run_CleanupRetry_Deadline(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	data := new(BasicStateBag)
+	step := &TestStepCleanupRetry{TestStepAcc: TestStepAcc{Data: "a"}, Errs: []error{errFlaky, errFlaky}}
+
+	r := &BasicRunner{
+		Steps: []Step{step},
+		CleanupRetry: CleanupRetry{
+			MaxAttempts: 3,
+			Backoff:     func(int) time.Duration { return time.Hour },
+		},
+		CleanupDeadline: 10 * time.Millisecond,
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	// The backoff is cut short by the deadline
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retries should stop at the cleanup deadline")
+	}
+
+	if step.Attempts != 1 {
+		t.Fatalf("bad attempts: %d", step.Attempts)
+	}
+	if errs := data.Get(StateCleanupErrors); !reflect.DeepEqual(errs, []error{errFlaky}) {
+		t.Fatalf("unexpected errors: %#v", errs)
+	}
+}
+
+func TestBasicRunner_Run_ActionFilter(t *testing.T) {
+	data := new(BasicStateBag)
+	observer := new(TestObserver)
+
+	var filtered []string
+	r := &BasicRunner{
+		Steps: []Step{
+			&TestStepAcc{Data: "a", Halt: true},
+			&TestStepAcc{Data: "b"},
+		},
+		Observers: []StepObserver{observer},
+		ActionFilter: func(index int, step Step, action StepAction, _ StateBag) StepAction {
+			filtered = append(filtered, fmt.Sprintf("%d %s %v", index, StepName(step), action))
+
+			// Best effort: carry on past halts
+			if action == ActionHalt {
+				return ActionContinue
+			}
+			return action
+		},
+	}
+	r.Run(context.Background(), data)
+
+	expected := []string{"0 TestStepAcc ActionHalt", "1 TestStepAcc ActionContinue"}
+	if !reflect.DeepEqual(filtered, expected) {
+		t.Fatalf("unexpected filtered actions: %#v", filtered)
+	}
+
+	if results := data.Get("data").([]string); !reflect.DeepEqual(results, []string{"a", "b"}) {
+		t.Fatalf("unexpected results: %#v", results)
+	}
+	if _, ok := data.GetOk(StateHalted); ok {
+		t.Fatal("run should not be halted")
+	}
+
+	// The observers see the rewritten action
+	if observer.Events[1] != fmt.Sprintf("end 0 %d", ActionContinue) {
+		t.Fatalf("unexpected events: %#v", observer.Events)
+	}
+}
+
+func TestBasicRunner_StepAt(t *testing.T) {
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b"}
+	r := &BasicRunner{Steps: []Step{stepA, stepB}}
+
+	if n := r.NumSteps(); n != 2 {
+		t.Fatalf("bad count: %d", n)
+	}
+
+	if r.StepAt(0) != stepA || r.StepAt(1) != stepB {
+		t.Errorf("bad steps: %#v, %#v", r.StepAt(0), r.StepAt(1))
+	}
+
+	for _, i := range []int{-1, 2} {
+		if step := r.StepAt(i); step != nil {
+			t.Errorf("%d: should be nil: %#v", i, step)
+		}
+	}
+
+	if n := new(BasicRunner).NumSteps(); n != 0 {
+		t.Errorf("bad count for an empty runner: %d", n)
+	}
+}
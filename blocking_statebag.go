@@ -0,0 +1,105 @@
+package multistep
+
+import (
+	"context"
+	"sync"
+)
+
+// BlockingStateBag is a BasicStateBag with WaitFor, which blocks until a
+// key is set. It lets steps running concurrently, for example in a
+// ParallelRunner, hand values to each other without polling.
+//
+// Waiters are woken by Put and PutChanged, and by PutIfAbsent and
+// CompareAndSwap when they change the bag.
+type BlockingStateBag struct {
+	BasicStateBag
+
+	waiters map[string][]chan interface{}
+	wl      sync.Mutex
+}
+
+func (b *BlockingStateBag) Put(k string, v interface{}) {
+	b.wl.Lock()
+	defer b.wl.Unlock()
+
+	b.BasicStateBag.Put(k, v)
+	b.wake(k, v)
+}
+
+func (b *BlockingStateBag) PutChanged(k string, v interface{}) bool {
+	b.wl.Lock()
+	defer b.wl.Unlock()
+
+	changed := b.BasicStateBag.PutChanged(k, v)
+	b.wake(k, v)
+	return changed
+}
+
+func (b *BlockingStateBag) PutIfAbsent(k string, v interface{}) bool {
+	b.wl.Lock()
+	defer b.wl.Unlock()
+
+	if !b.BasicStateBag.PutIfAbsent(k, v) {
+		return false
+	}
+
+	b.wake(k, v)
+	return true
+}
+
+func (b *BlockingStateBag) CompareAndSwap(k string, old, new interface{}) bool {
+	b.wl.Lock()
+	defer b.wl.Unlock()
+
+	if !b.BasicStateBag.CompareAndSwap(k, old, new) {
+		return false
+	}
+
+	b.wake(k, new)
+	return true
+}
+
+// WaitFor returns the value of k, waiting for it to be Put if it isn't set
+// yet. It returns false if ctx is done before then.
+func (b *BlockingStateBag) WaitFor(ctx context.Context, k string) (interface{}, bool) {
+	b.wl.Lock()
+	if v, ok := b.BasicStateBag.GetOk(k); ok {
+		b.wl.Unlock()
+		return v, true
+	}
+
+	ch := make(chan interface{}, 1)
+	if b.waiters == nil {
+		b.waiters = make(map[string][]chan interface{})
+	}
+	b.waiters[k] = append(b.waiters[k], ch)
+	b.wl.Unlock()
+
+	select {
+	case v := <-ch:
+		return v, true
+	case <-ctx.Done():
+		b.wl.Lock()
+		defer b.wl.Unlock()
+
+		for i, c := range b.waiters[k] {
+			if c == ch {
+				b.waiters[k] = append(b.waiters[k][:i], b.waiters[k][i+1:]...)
+				break
+			}
+		}
+
+		return nil, false
+	}
+}
+
+// wake hands v to everything waiting for k. It must be called with wl
+// held.
+func (b *BlockingStateBag) wake(k string, v interface{}) {
+	for _, ch := range b.waiters[k] {
+		// Each channel has room for exactly this value.
+		ch <- v
+	}
+
+	delete(b.waiters, k)
+}
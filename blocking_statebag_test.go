@@ -0,0 +1,110 @@
+package multistep
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBlockingStateBag_ImplStateBag(t *testing.T) {
+	var raw interface{}
+	raw = &BlockingStateBag{}
+	if _, ok := raw.(StateBag); !ok {
+		t.Fatalf("must be a StateBag")
+	}
+}
+
+func TestBlockingStateBag_WaitFor(t *testing.T) {
+	b := new(BlockingStateBag)
+
+	resultCh := make(chan interface{})
+	go func() {
+		v, ok := b.WaitFor(context.Background(), "foo")
+		if !ok {
+			t.Error("should have the value")
+		}
+		resultCh <- v
+	}()
+
+	select {
+	case v := <-resultCh:
+		t.Fatalf("should wait for the key: %#v", v)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	b.Put("foo", "bar")
+
+	select {
+	case v := <-resultCh:
+		if v != "bar" {
+			t.Fatalf("bad: %#v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("should be woken by Put")
+	}
+}
+
+func TestBlockingStateBag_WaitFor_Existing(t *testing.T) {
+	b := new(BlockingStateBag)
+	b.Put("foo", "bar")
+
+	v, ok := b.WaitFor(context.Background(), "foo")
+	if !ok || v != "bar" {
+		t.Fatalf("bad: %#v", v)
+	}
+}
+
+func TestBlockingStateBag_WaitFor_PutIfAbsent(t *testing.T) {
+	b := new(BlockingStateBag)
+
+	go b.PutIfAbsent("foo", "bar")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	v, ok := b.WaitFor(ctx, "foo")
+	if !ok || v != "bar" {
+		t.Fatalf("bad: %#v", v)
+	}
+}
+
+func TestBlockingStateBag_WaitFor_Cancel(t *testing.T) {
+	b := new(BlockingStateBag)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if v, ok := b.WaitFor(ctx, "foo"); ok {
+		t.Fatalf("should not have a value: %#v", v)
+	}
+
+	// The abandoned waiter must not block later writes
+	b.Put("foo", "bar")
+	if len(b.waiters) != 0 {
+		t.Fatalf("bad waiters: %#v", b.waiters)
+	}
+}
+
+func TestBlockingStateBag_ParallelRunner(t *testing.T) {
+	data := new(BlockingStateBag)
+	consumer := &FuncStep{RunFunc: func(ctx context.Context, state StateBag) StepAction {
+		v, ok := state.(*BlockingStateBag).WaitFor(ctx, "value")
+		if !ok {
+			return ActionHalt
+		}
+
+		state.Put("consumed", v)
+		return ActionContinue
+	}}
+	producer := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		state.Put("value", 42)
+		return ActionContinue
+	}}
+
+	r := &ParallelRunner{Steps: []Step{consumer, producer}}
+	r.Run(context.Background(), data)
+
+	if data.Get("consumed") != 42 {
+		t.Fatalf("bad: %#v", data.Get("consumed"))
+	}
+}
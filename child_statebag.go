@@ -0,0 +1,39 @@
+package multistep
+
+// ChildStateBag is a StateBag layered on top of a parent bag, giving a
+// nested sequence of steps its own scratch space while still being able to
+// read shared values from the parent.
+//
+// Get and GetOk look in the child first and fall back to the parent if
+// the key isn't there. Put and Remove only ever change the child, so the
+// parent is never modified. Note that this means removing a key that is
+// also in the parent makes the parent's value visible again.
+//
+// A ChildStateBag is safe for concurrent use as long as the parent is.
+type ChildStateBag struct {
+	// Parent is the bag to fall back to.
+	Parent StateBag
+
+	local BasicStateBag
+}
+
+func (c *ChildStateBag) Get(k string) interface{} {
+	result, _ := c.GetOk(k)
+	return result
+}
+
+func (c *ChildStateBag) GetOk(k string) (interface{}, bool) {
+	if result, ok := c.local.GetOk(k); ok {
+		return result, true
+	}
+
+	return c.Parent.GetOk(k)
+}
+
+func (c *ChildStateBag) Put(k string, v interface{}) {
+	c.local.Put(k, v)
+}
+
+func (c *ChildStateBag) Remove(k string) {
+	c.local.Remove(k)
+}
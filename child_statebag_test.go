@@ -0,0 +1,58 @@
+package multistep
+
+import (
+	"testing"
+)
+
+func TestChildStateBag_ImplStateBag(t *testing.T) {
+	var raw interface{}
+	raw = &ChildStateBag{}
+	if _, ok := raw.(StateBag); !ok {
+		t.Fatalf("must be a StateBag")
+	}
+}
+
+func TestChildStateBag(t *testing.T) {
+	parent := new(BasicStateBag)
+	parent.Put("config", "global")
+	parent.Put("shared", "parent")
+
+	child := &ChildStateBag{Parent: parent}
+
+	// Reads fall back to the parent
+	if v := child.Get("config"); v != "global" {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	if _, ok := child.GetOk("missing"); ok {
+		t.Fatal("should not have missing")
+	}
+
+	// Writes only go to the child, and shadow the parent
+	child.Put("shared", "child")
+	child.Put("scratch", true)
+
+	if v := child.Get("shared"); v != "child" {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	if v := parent.Get("shared"); v != "parent" {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	if _, ok := parent.GetOk("scratch"); ok {
+		t.Fatal("parent should not have scratch")
+	}
+
+	// Removing only affects the child
+	child.Remove("shared")
+	child.Remove("config")
+
+	if v := child.Get("shared"); v != "parent" {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	if v := parent.Get("config"); v != "global" {
+		t.Fatalf("bad: %#v", v)
+	}
+}
@@ -0,0 +1,152 @@
+package multistep
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time used by the runners and steps in this
+// package that wait, so that tests can control time with a FakeClock
+// instead of really waiting.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that receives the current time once d has
+	// passed.
+	After(d time.Duration) <-chan time.Time
+
+	// Sleep waits for d to pass.
+	Sleep(d time.Duration)
+}
+
+// RealClock is the Clock that uses the real time, as provided by the time
+// package. It is used wherever a Clock is not set.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// clockOrReal returns the clock, or RealClock if it is nil.
+func clockOrReal(clock Clock) Clock {
+	if clock == nil {
+		return RealClock
+	}
+
+	return clock
+}
+
+// withClockTimeout is like context.WithTimeout, but measures the timeout
+// with the given clock. When the timeout passes on a clock other than the
+// real one, the context is cancelled with context.DeadlineExceeded as its
+// cause, as returned by context.Cause.
+func withClockTimeout(ctx context.Context, clock Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if clock == nil || clock == RealClock {
+		return context.WithTimeout(ctx, d)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	timeout := clock.After(d)
+	go func() {
+		select {
+		case <-timeout:
+			cancel(context.DeadlineExceeded)
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// withClockDeadline is like context.WithDeadline, but measures the time
+// left until the deadline with the given clock, as withClockTimeout does.
+func withClockDeadline(ctx context.Context, clock Clock, deadline time.Time) (context.Context, context.CancelFunc) {
+	if clock == nil || clock == RealClock {
+		return context.WithDeadline(ctx, deadline)
+	}
+
+	return withClockTimeout(ctx, clock, deadline.Sub(clock.Now()))
+}
+
+// FakeClock is a Clock for tests, whose time only moves when Advance is
+// called. It is safe for concurrent use.
+type FakeClock struct {
+	now     time.Time
+	waiters []fakeWaiter
+	l       sync.Mutex
+	cond    *sync.Cond
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewFakeClock returns a FakeClock whose time starts at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.l)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	c.cond.Broadcast()
+	return ch
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Advance moves the time of the clock forward by d, firing everything
+// waiting for a time up to the new one.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.now = c.now.Add(d)
+
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+// WaitForWaiters blocks until at least n calls to After or Sleep are
+// waiting for the clock to advance, so that a test can be sure the code
+// under test is waiting before calling Advance.
+func (c *FakeClock) WaitForWaiters(n int) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
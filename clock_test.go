@@ -0,0 +1,62 @@
+package multistep
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock_ImplClock(t *testing.T) {
+	var raw interface{}
+	raw = &FakeClock{}
+	if _, ok := raw.(Clock); !ok {
+		t.Fatalf("FakeClock must be a Clock")
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+
+	ch := c.After(time.Minute)
+	c.Advance(30 * time.Second)
+
+	select {
+	case <-ch:
+		t.Fatal("should not fire before its time")
+	default:
+	}
+
+	c.Advance(30 * time.Second)
+
+	select {
+	case now := <-ch:
+		if !now.Equal(start.Add(time.Minute)) {
+			t.Fatalf("bad time: %s", now)
+		}
+	default:
+		t.Fatal("should fire once its time has come")
+	}
+
+	if !c.Now().Equal(start.Add(time.Minute)) {
+		t.Fatalf("bad now: %s", c.Now())
+	}
+}
+
+func TestFakeClock_Sleep(t *testing.T) {
+	c := NewFakeClock(time.Now())
+
+	doneCh := make(chan struct{})
+	go func() {
+		c.Sleep(time.Hour)
+		close(doneCh)
+	}()
+
+	c.WaitForWaiters(1)
+	c.Advance(time.Hour)
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("sleep should return once the clock advances")
+	}
+}
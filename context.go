@@ -0,0 +1,48 @@
+package multistep
+
+import "context"
+
+type contextKey int
+
+const (
+	stateBagKey contextKey = iota
+	stepIndexKey
+	stepNameKey
+)
+
+// WithStateBag returns a copy of ctx carrying the given state bag, so that
+// code deep inside a step can get at it without it being passed around.
+func WithStateBag(ctx context.Context, state StateBag) context.Context {
+	return context.WithValue(ctx, stateBagKey, state)
+}
+
+// StateBagFromContext returns the state bag carried by ctx, if any. The
+// BasicRunner puts the state bag of the run into the context given to each
+// step. This is the very same bag the step is given, not a copy, so
+// changes made to it change the state of the run.
+func StateBagFromContext(ctx context.Context) (StateBag, bool) {
+	state, ok := ctx.Value(stateBagKey).(StateBag)
+	return state, ok
+}
+
+// withStep returns a copy of ctx carrying the index and name of a step.
+func withStep(ctx context.Context, index int, name string) context.Context {
+	ctx = context.WithValue(ctx, stepIndexKey, index)
+	return context.WithValue(ctx, stepNameKey, name)
+}
+
+// StepIndexFromContext returns the index of the step that ctx was given
+// to, if any. The BasicRunner puts it into the context given to each
+// step's Run and to its cleanup, for cleanups that take a context, so that
+// logging inside a step can say which step it came from.
+func StepIndexFromContext(ctx context.Context) (int, bool) {
+	index, ok := ctx.Value(stepIndexKey).(int)
+	return index, ok
+}
+
+// StepNameFromContext returns the name, as given by StepName, of the step
+// that ctx was given to, if any. See StepIndexFromContext.
+func StepNameFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(stepNameKey).(string)
+	return name, ok
+}
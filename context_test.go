@@ -0,0 +1,107 @@
+package multistep
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestStateBagFromContext(t *testing.T) {
+	if _, ok := StateBagFromContext(context.Background()); ok {
+		t.Fatal("should not have a state bag")
+	}
+
+	state := new(BasicStateBag)
+	ctx := WithStateBag(context.Background(), state)
+
+	actual, ok := StateBagFromContext(ctx)
+	if !ok || actual != state {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestBasicRunner_Run_StateBagInContext(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &FuncStep{RunFunc: func(ctx context.Context, _ StateBag) StepAction {
+		state, ok := StateBagFromContext(ctx)
+		if !ok {
+			t.Fatal("should have a state bag")
+		}
+
+		state.Put("seen", true)
+		return ActionContinue
+	}}
+
+	r := &BasicRunner{Steps: []Step{step}}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk("seen"); !ok {
+		t.Fatal("state bag from context should be the run's state bag")
+	}
+}
+
+func TestStepFromContext(t *testing.T) {
+	if _, ok := StepIndexFromContext(context.Background()); ok {
+		t.Fatal("should not have a step index")
+	}
+
+	if _, ok := StepNameFromContext(context.Background()); ok {
+		t.Fatal("should not have a step name")
+	}
+}
+
+func TestBasicRunner_Run_StepInContext(t *testing.T) {
+	data := new(BasicStateBag)
+
+	var seen []string
+	record := func(ctx context.Context) {
+		index, _ := StepIndexFromContext(ctx)
+		name, _ := StepNameFromContext(ctx)
+		seen = append(seen, fmt.Sprintf("%d %s", index, name))
+	}
+
+	step := &FuncStep{RunFunc: func(ctx context.Context, _ StateBag) StepAction {
+		record(ctx)
+		return ActionContinue
+	}}
+	cleanup := &TestStepCleanupContext{TestStepAcc: TestStepAcc{Data: "b"}}
+
+	r := &BasicRunner{Steps: []Step{
+		&NamedStep{StepName: "first", Step: step},
+		cleanup,
+		step,
+	}}
+	r.Run(context.Background(), data)
+	record(cleanup.Ctx)
+
+	expected := []string{"0 first", "2 FuncStep", "1 TestStepCleanupContext"}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Errorf("unexpected steps: %#v", seen)
+	}
+}
+
+func TestBasicRunner_Run_StepInContext_ContextFunc(t *testing.T) {
+	type key struct{}
+
+	var seen []string
+	step := &FuncStep{RunFunc: func(ctx context.Context, _ StateBag) StepAction {
+		index, _ := StepIndexFromContext(ctx)
+		name, _ := StepNameFromContext(ctx)
+		seen = append(seen, fmt.Sprintf("%d %s %v", index, name, ctx.Value(key{})))
+		return ActionContinue
+	}}
+
+	r := &BasicRunner{
+		Steps: []Step{step, &NamedStep{StepName: "second", Step: step}},
+		ContextFunc: func(ctx context.Context, _ int, _ Step, _ StateBag) context.Context {
+			return context.WithValue(ctx, key{}, true)
+		},
+	}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	expected := []string{"0 FuncStep true", "1 second true"}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Errorf("unexpected steps: %#v", seen)
+	}
+}
@@ -3,19 +3,20 @@ package multistep
 import (
 	"context"
 	"fmt"
-	"reflect"
 	"sync"
 )
 
 // DebugLocation is the location where the pause is occuring when debugging
 // a step sequence. "DebugLocationAfterRun" is after the run of the named
 // step. "DebugLocationBeforeCleanup" is before the cleanup of the named
-// step.
+// step. "DebugLocationBeforeRun" is before the run of the named step, and
+// is only used if PauseBeforeRun is set.
 type DebugLocation uint
 
 const (
 	DebugLocationAfterRun DebugLocation = iota
 	DebugLocationBeforeCleanup
+	DebugLocationBeforeRun
 )
 
 // StepWrapper is an interface that wrapped steps can implement to expose their
@@ -30,6 +31,37 @@ type StepWrapper interface {
 // inspect the state of the multi-step sequence at a given step.
 type DebugPauseFn func(DebugLocation, string, StateBag)
 
+// DebugPauseContext describes where a DebugRunner is paused, for a
+// DebugPauseContextFn.
+type DebugPauseContext struct {
+	// Location is where in the step the runner is paused.
+	Location DebugLocation
+
+	// Index is the index into Steps of the step being paused at.
+	Index int
+
+	// Name is the human readable name of the step, as from StepName.
+	Name string
+
+	// Step is the step being paused at.
+	Step Step
+
+	// State is the state bag of the run.
+	State StateBag
+
+	// Cancelling is true if the run is being cancelled, for example so
+	// that the pause can carry on straight away during the teardown.
+	Cancelling bool
+
+	// Action is the action the step returned, for a pause at
+	// DebugLocationAfterRun. It is ActionContinue anywhere else.
+	Action StepAction
+}
+
+// DebugPauseContextFn is like DebugPauseFn, but is given a DebugPauseContext
+// describing the pause in full.
+type DebugPauseContextFn func(DebugPauseContext)
+
 // DebugRunner is a Runner that runs the given set of steps in order,
 // but pauses between each step until it is told to continue.
 type DebugRunner struct {
@@ -41,8 +73,28 @@ type DebugRunner struct {
 	// The function is given the state so that the state can be inspected.
 	PauseFn DebugPauseFn
 
+	// PauseBeforeRun, if true, also pauses before each step is run, so
+	// that the sequence can be stepped through one step at a time. If the
+	// run is cancelled while paused here, the pause returns straight away
+	// and the step is not run.
+	PauseBeforeRun bool
+
+	// PauseContextFn, if set, is called instead of PauseFn whenever the
+	// debug runner pauses, with a DebugPauseContext describing the pause.
+	// After each step is run it is given the action the step returned, so
+	// that the effects of the step can be examined before the next runs.
+	//
+	// If the run is cancelled while paused before or after the run of a
+	// step, the pause returns straight away.
+	//
+	// A pause that is abandoned like this is left running, and the pauses
+	// before the cleanups are still made while it is, so PauseFn and
+	// PauseContextFn must be safe for concurrent use.
+	PauseContextFn DebugPauseContextFn
+
 	l      sync.Mutex
 	runner *BasicRunner
+	cancel context.CancelCauseFunc
 }
 
 func (r *DebugRunner) Run(ctx context.Context, state StateBag) {
@@ -50,43 +102,60 @@ func (r *DebugRunner) Run(ctx context.Context, state StateBag) {
 	if r.runner != nil {
 		panic("already running")
 	}
+	runCtx, cancel := context.WithCancelCause(ctx)
 	r.runner = new(BasicRunner)
+	r.cancel = cancel
 	r.l.Unlock()
 
-	pauseFn := r.PauseFn
+	defer func() {
+		r.l.Lock()
+		r.runner = nil
+		r.cancel = nil
+		r.l.Unlock()
+		cancel(context.Canceled)
+	}()
 
-	// If no PauseFn is specified, use the default
+	pauseFn := r.PauseContextFn
 	if pauseFn == nil {
-		pauseFn = DebugPauseDefault
+		fn := r.PauseFn
+
+		// If no PauseFn is specified, use the default
+		if fn == nil {
+			fn = DebugPauseDefault
+		}
+
+		pauseFn = func(c DebugPauseContext) {
+			fn(c.Location, c.Name, c.State)
+		}
 	}
 
 	// Rebuild the steps so that we insert the pause step after each
 	steps := make([]Step, len(r.Steps))
 	for i, step := range r.Steps {
-		name := ""
-		if wrapped, ok := step.(StepWrapper); ok {
-			name = wrapped.InnerStepName()
-		} else {
-			name = reflect.Indirect(reflect.ValueOf(step)).Type().Name()
-		}
 		steps[i] = &debugStepPause{
-			name,
+			i,
+			StepName(step),
 			step,
 			pauseFn,
+			r.PauseBeforeRun,
 		}
 	}
 
 	// Then just use a basic runner to run it
 	r.runner.Steps = steps
-	r.runner.Run(ctx, state)
+	r.runner.Run(runCtx, state)
 }
 
 func (r *DebugRunner) Cancel() {
 	r.l.Lock()
-	defer r.l.Unlock()
+	runner, cancel := r.runner, r.cancel
+	r.l.Unlock()
 
-	if r.runner != nil {
-		r.runner.Cancel()
+	if runner != nil {
+		// Cancelling the context also cancels a run that hasn't started,
+		// and unlike Cancel never cancels the next run of the runner.
+		cancel(ErrUserCancel)
+		runner.cancelRunning()
 	}
 }
 
@@ -101,6 +170,8 @@ func DebugPauseDefault(loc DebugLocation, name string, state StateBag) {
 		locationString = "after run of"
 	case DebugLocationBeforeCleanup:
 		locationString = "before cleanup of"
+	case DebugLocationBeforeRun:
+		locationString = "before run of"
 	}
 
 	fmt.Printf("Pausing %s step '%s'. Press any key to continue.\n", locationString, name)
@@ -110,18 +181,75 @@ func DebugPauseDefault(loc DebugLocation, name string, state StateBag) {
 }
 
 type debugStepPause struct {
-	StepName string
-	Step     Step
-	PauseFn  DebugPauseFn
+	Index          int
+	StepName       string
+	Step           Step
+	PauseFn        DebugPauseContextFn
+	PauseBeforeRun bool
 }
 
 func (s *debugStepPause) Run(ctx context.Context, state StateBag) StepAction {
+	if s.PauseBeforeRun {
+		s.pauseCtx(ctx, s.pauseContext(DebugLocationBeforeRun, state, ctx.Err() != nil || isCancelled(state)))
+
+		// If we were cancelled while paused, don't run the step at all.
+		// We flag the cancel ourselves so that the runner stops even if
+		// its goroutine hasn't gotten to it yet.
+		if ctx.Err() != nil {
+			state.Put(StateCancelled, true)
+			return ActionSkip
+		}
+	}
+
 	action := s.Step.Run(ctx, state)
-	s.PauseFn(DebugLocationAfterRun, s.StepName, state)
+
+	c := s.pauseContext(DebugLocationAfterRun, state, ctx.Err() != nil || isCancelled(state))
+	c.Action = action
+	s.pauseCtx(ctx, c)
 	return action
 }
 
 func (s *debugStepPause) Cleanup(state StateBag) {
-	s.PauseFn(DebugLocationBeforeCleanup, s.StepName, state)
+	s.PauseFn(s.pauseContext(DebugLocationBeforeCleanup, state, isCancelled(state)))
 	s.Step.Cleanup(state)
 }
+
+// pauseCtx pauses like PauseFn, but returns early if the context is
+// cancelled while paused. The PauseFn is left to return on its own in that
+// case, possibly while the cleanup pauses call it again. If the run is already being cancelled the pause is synchronous, so
+// that a PauseFn reading stdin, like DebugPauseDefault, isn't abandoned
+// while it is reading.
+func (s *debugStepPause) pauseCtx(ctx context.Context, c DebugPauseContext) {
+	if c.Cancelling {
+		s.PauseFn(c)
+		return
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		s.PauseFn(c)
+	}()
+
+	select {
+	case <-doneCh:
+	case <-ctx.Done():
+	}
+}
+
+func (s *debugStepPause) pauseContext(loc DebugLocation, state StateBag, cancelling bool) DebugPauseContext {
+	return DebugPauseContext{
+		Location:   loc,
+		Index:      s.Index,
+		Name:       s.StepName,
+		Step:       s.Step,
+		State:      state,
+		Cancelling: cancelling,
+	}
+}
+
+// isCancelled reports whether the state bag says the run was cancelled.
+func isCancelled(state StateBag) bool {
+	_, ok := state.GetOk(StateCancelled)
+	return ok
+}
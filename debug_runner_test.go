@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -210,3 +211,240 @@ func TestDebugRunner_Halt(t *testing.T) {
 		t.Errorf("unexpected results: %#v", results)
 	}
 }
+
+func TestDebugRunner_PauseBeforeRun(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b"}
+
+	var locations []DebugLocation
+	pauseFn := func(loc DebugLocation, name string, state StateBag) {
+		locations = append(locations, loc)
+	}
+
+	r := &DebugRunner{
+		Steps:          []Step{stepA, stepB},
+		PauseFn:        pauseFn,
+		PauseBeforeRun: true,
+	}
+	r.Run(context.Background(), data)
+
+	expected := []DebugLocation{
+		DebugLocationBeforeRun, DebugLocationAfterRun,
+		DebugLocationBeforeRun, DebugLocationAfterRun,
+		DebugLocationBeforeCleanup, DebugLocationBeforeCleanup,
+	}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Errorf("unexpected locations: %#v", locations)
+	}
+}
+
+func TestDebugRunner_PauseBeforeRun_Cancel(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b"}
+
+	count := 0
+	paused := make(chan struct{}, 2)
+	pauseFn := func(loc DebugLocation, name string, state StateBag) {
+		if loc != DebugLocationBeforeRun {
+			return
+		}
+
+		count++
+		paused <- struct{}{}
+
+		// Block before the second step forever; cancelling must unblock
+		// the runner
+		if count == 2 {
+			select {}
+		}
+	}
+
+	r := &DebugRunner{
+		Steps:          []Step{stepA, stepB},
+		PauseFn:        pauseFn,
+		PauseBeforeRun: true,
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	<-paused
+	<-paused
+	r.Cancel()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("pause did not return on cancel")
+	}
+
+	// The paused step never ran
+	expected := []string{"a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Errorf("cancelled should be in state bag")
+	}
+}
+
+func TestDebugRunner_PauseContextFn(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &NamedStep{StepName: "b", Step: &TestStepAcc{Data: "b"}}
+
+	var pauses []DebugPauseContext
+	r := &DebugRunner{
+		Steps: []Step{stepA, stepB},
+		PauseFn: func(DebugLocation, string, StateBag) {
+			t.Error("PauseFn should not be called")
+		},
+		PauseContextFn: func(c DebugPauseContext) {
+			pauses = append(pauses, c)
+		},
+	}
+	r.Run(context.Background(), data)
+
+	expected := []DebugPauseContext{
+		{DebugLocationAfterRun, 0, "TestStepAcc", stepA, data, false, ActionContinue},
+		{DebugLocationAfterRun, 1, "b", stepB, data, false, ActionContinue},
+		{DebugLocationBeforeCleanup, 1, "b", stepB, data, false, ActionContinue},
+		{DebugLocationBeforeCleanup, 0, "TestStepAcc", stepA, data, false, ActionContinue},
+	}
+	if !reflect.DeepEqual(pauses, expected) {
+		t.Errorf("unexpected pauses: %#v", pauses)
+	}
+}
+
+func TestDebugRunner_PauseContextFn_Cancel(t *testing.T) {
+	data := new(BasicStateBag)
+
+	var l sync.Mutex
+	var cleanupPauses []DebugPauseContext
+	paused := make(chan struct{})
+	r := &DebugRunner{
+		Steps: []Step{&TestStepAcc{Data: "a"}, &TestStepAcc{Data: "b"}},
+		PauseContextFn: func(c DebugPauseContext) {
+			if c.Location == DebugLocationBeforeCleanup {
+				l.Lock()
+				defer l.Unlock()
+				cleanupPauses = append(cleanupPauses, c)
+				return
+			}
+
+			if c.Location != DebugLocationBeforeRun || c.Index != 1 {
+				return
+			}
+
+			// Block before the second step forever; cancelling must
+			// unblock the runner
+			close(paused)
+			select {}
+		},
+		PauseBeforeRun: true,
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	<-paused
+	r.Cancel()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("pause did not return on cancel")
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if len(cleanupPauses) != 1 || !cleanupPauses[0].Cancelling {
+		t.Fatalf("cleanup pause should say the run is cancelling: %#v", cleanupPauses)
+	}
+
+	expected := []string{"a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+func TestDebugRunner_PauseContextFn_Action(t *testing.T) {
+	data := new(BasicStateBag)
+
+	var actions []StepAction
+	r := &DebugRunner{
+		Steps: []Step{&TestStepAcc{Data: "a", Skip: true}, &TestStepAcc{Data: "b", Halt: true}},
+		PauseContextFn: func(c DebugPauseContext) {
+			if c.Location == DebugLocationAfterRun {
+				actions = append(actions, c.Action)
+			}
+		},
+	}
+	r.Run(context.Background(), data)
+
+	if !reflect.DeepEqual(actions, []StepAction{ActionSkip, ActionHalt}) {
+		t.Fatalf("unexpected actions: %#v", actions)
+	}
+}
+
+func TestDebugRunner_PauseAfterRun_Cancel(t *testing.T) {
+	data := new(BasicStateBag)
+
+	paused := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	r := &DebugRunner{
+		Steps: []Step{&TestStepAcc{Data: "a"}, &TestStepAcc{Data: "b"}},
+		PauseContextFn: func(c DebugPauseContext) {
+			if c.Location != DebugLocationAfterRun || c.Index != 0 {
+				return
+			}
+
+			// Block after the first step; cancelling must unblock the
+			// runner
+			close(paused)
+			<-release
+		},
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	<-paused
+	r.Cancel()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("pause did not return on cancel")
+	}
+
+	expected := []string{"a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Errorf("cancelled should be in state bag")
+	}
+}
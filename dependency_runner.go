@@ -0,0 +1,143 @@
+package multistep
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DependentStep is a step for a DependencyRunner, along with the names of
+// the steps that must run before it.
+type DependentStep struct {
+	// Name is the unique name of the step.
+	Name string
+
+	// Step is the step to run.
+	Step Step
+
+	// Requires are the names of the steps that must run before this one.
+	Requires []string
+}
+
+// DependencyRunner is a Runner that works out the order to run its steps
+// in from their dependencies, and then runs them in that order exactly
+// like a BasicRunner, with the same cancellation and cleanup behavior.
+//
+// Steps are run as early as their dependencies allow, and steps that don't
+// depend on each other keep the order they were given in. If the
+// dependencies can't be satisfied, because of a cycle or a missing step,
+// nothing is run: the error is put into the state bag under StateError
+// and the run is halted.
+type DependencyRunner struct {
+	// Steps is the steps to run. Once set, this should _not_ be modified.
+	Steps []DependentStep
+
+	l      sync.Mutex
+	runner *BasicRunner
+	cancel context.CancelCauseFunc
+}
+
+// Order returns the steps in the order they would be run, each wrapped in
+// a NamedStep, or an error if the dependencies can't be satisfied. The
+// runner looks through the NamedStep, so the optional interfaces of the
+// steps, such as StepWithCleanupContext, work as they do in a BasicRunner.
+func (r *DependencyRunner) Order() ([]Step, error) {
+	byName := make(map[string]bool, len(r.Steps))
+	for _, s := range r.Steps {
+		if byName[s.Name] {
+			return nil, fmt.Errorf("multistep: duplicate step %q", s.Name)
+		}
+		byName[s.Name] = true
+	}
+
+	for _, s := range r.Steps {
+		for _, dep := range s.Requires {
+			if !byName[dep] {
+				return nil, fmt.Errorf("multistep: step %q requires unknown step %q", s.Name, dep)
+			}
+		}
+	}
+
+	done := make(map[string]bool, len(r.Steps))
+	result := make([]Step, 0, len(r.Steps))
+	for len(result) < len(r.Steps) {
+		// Pick the first step not yet ordered whose dependencies are.
+		progress := false
+		for _, s := range r.Steps {
+			if done[s.Name] || !allDone(done, s.Requires) {
+				continue
+			}
+
+			done[s.Name] = true
+			result = append(result, &NamedStep{StepName: s.Name, Step: s.Step})
+			progress = true
+			break
+		}
+
+		if !progress {
+			var cycle []string
+			for _, s := range r.Steps {
+				if !done[s.Name] {
+					cycle = append(cycle, s.Name)
+				}
+			}
+
+			return nil, fmt.Errorf("multistep: dependency cycle in steps %s", strings.Join(cycle, ", "))
+		}
+	}
+
+	return result, nil
+}
+
+func allDone(done map[string]bool, names []string) bool {
+	for _, name := range names {
+		if !done[name] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *DependencyRunner) Run(ctx context.Context, state StateBag) {
+	steps, err := r.Order()
+	if err != nil {
+		state.Put(StateError, err)
+		state.Put(StateHalted, true)
+		return
+	}
+
+	r.l.Lock()
+	if r.runner != nil {
+		r.l.Unlock()
+		panic("already running")
+	}
+	runCtx, cancel := context.WithCancelCause(ctx)
+	r.runner = &BasicRunner{Steps: steps}
+	r.cancel = cancel
+	r.l.Unlock()
+
+	defer func() {
+		r.l.Lock()
+		r.runner = nil
+		r.cancel = nil
+		r.l.Unlock()
+		cancel(context.Canceled)
+	}()
+
+	r.runner.Run(runCtx, state)
+}
+
+func (r *DependencyRunner) Cancel() {
+	r.l.Lock()
+	runner, cancel := r.runner, r.cancel
+	r.l.Unlock()
+
+	if runner != nil {
+		// Cancelling the context also cancels a run that hasn't started,
+		// and unlike Cancel never cancels the next run of the runner.
+		cancel(ErrUserCancel)
+		runner.cancelRunning()
+	}
+}
@@ -0,0 +1,163 @@
+package multistep
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDependencyRunner_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &DependencyRunner{}
+	if _, ok := raw.(Runner); !ok {
+		t.Fatalf("DependencyRunner must be a Runner")
+	}
+}
+
+func TestDependencyRunner_Run(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &DependencyRunner{Steps: []DependentStep{
+		{Name: "deploy", Step: &TestStepAcc{Data: "deploy"}, Requires: []string{"build", "network"}},
+		{Name: "build", Step: &TestStepAcc{Data: "build"}},
+		{Name: "network", Step: &TestStepAcc{Data: "network"}, Requires: []string{"vpc"}},
+		{Name: "vpc", Step: &TestStepAcc{Data: "vpc"}},
+	}}
+	r.Run(context.Background(), data)
+
+	expected := []string{"build", "vpc", "network", "deploy"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+
+	expected = []string{"deploy", "network", "vpc", "build"}
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+}
+
+func TestDependencyRunner_Run_CleanupWithContext(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TestStepCleanupContext{TestStepAcc: TestStepAcc{Data: "b"}}
+	r := &DependencyRunner{Steps: []DependentStep{
+		{Name: "b", Step: step, Requires: []string{"a"}},
+		{Name: "a", Step: &TestStepAcc{Data: "a"}},
+	}}
+	r.Run(context.Background(), data)
+
+	if step.Ctx == nil {
+		t.Fatal("CleanupWithContext should be called")
+	}
+
+	expected := []string{"b", "a"}
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+}
+
+func TestDependencyRunner_Run_Halt(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &DependencyRunner{Steps: []DependentStep{
+		{Name: "b", Step: &TestStepAcc{Data: "b"}, Requires: []string{"a"}},
+		{Name: "a", Step: &TestStepAcc{Data: "a", Halt: true}},
+	}}
+	r.Run(context.Background(), data)
+
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, []string{"a"}) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Fatal("halted should be in state bag")
+	}
+}
+
+func TestDependencyRunner_Run_Invalid(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Steps []DependentStep
+		Err   string
+	}{
+		{
+			"cycle",
+			[]DependentStep{
+				{Name: "ok", Step: &TestStepAcc{Data: "ok"}},
+				{Name: "a", Step: &TestStepAcc{Data: "a"}, Requires: []string{"b"}},
+				{Name: "b", Step: &TestStepAcc{Data: "b"}, Requires: []string{"a"}},
+			},
+			"dependency cycle in steps a, b",
+		},
+		{
+			"unknown",
+			[]DependentStep{{Name: "a", Step: &TestStepAcc{Data: "a"}, Requires: []string{"nope"}}},
+			`step "a" requires unknown step "nope"`,
+		},
+		{
+			"duplicate",
+			[]DependentStep{
+				{Name: "a", Step: &TestStepAcc{Data: "a"}},
+				{Name: "a", Step: &TestStepAcc{Data: "a"}},
+			},
+			`duplicate step "a"`,
+		},
+	}
+
+	for _, tc := range cases {
+		data := new(BasicStateBag)
+		r := &DependencyRunner{Steps: tc.Steps}
+		r.Run(context.Background(), data)
+
+		if _, ok := data.GetOk("data"); ok {
+			t.Errorf("%s: nothing should run", tc.Name)
+		}
+
+		if _, ok := data.GetOk(StateHalted); !ok {
+			t.Errorf("%s: halted should be in state bag", tc.Name)
+		}
+
+		err := GetError(data)
+		if err == nil || !strings.Contains(err.Error(), tc.Err) {
+			t.Errorf("%s: bad error: %v", tc.Name, err)
+		}
+	}
+}
+
+func TestDependencyRunner_Cancel(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+	r := &DependencyRunner{Steps: []DependentStep{
+		{Name: "a", Step: &TestStepAcc{Data: "a"}},
+		{Name: "wait", Step: step, Requires: []string{"a"}},
+		{Name: "b", Step: &TestStepAcc{Data: "b"}, Requires: []string{"wait"}},
+	}}
+
+	// cancelling an idle Runner is a no-op
+	r.Cancel()
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	<-step.Started
+	r.Cancel()
+	<-doneCh
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Fatal("cancelled should be in state bag")
+	}
+
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, []string{"a"}) {
+		t.Fatalf("unexpected result: %#v", results)
+	}
+
+	if !step.CleanedUp {
+		t.Fatal("step should be cleaned up")
+	}
+}
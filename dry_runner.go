@@ -0,0 +1,79 @@
+package multistep
+
+import (
+	"context"
+	"sync"
+)
+
+// DryRunner is a Runner that walks through the given steps in order
+// without running them, so that a step sequence can be checked or printed
+// without any side effects. Neither Run nor Cleanup is called on any step.
+type DryRunner struct {
+	// Steps is the steps to walk through.
+	Steps []Step
+
+	// PlanFn, if set, is called with each step and its index in order,
+	// for example to log what would be run.
+	PlanFn func(index int, step Step)
+
+	cancel context.CancelFunc
+	doneCh chan struct{}
+	state  runState
+	l      sync.Mutex
+}
+
+func (d *DryRunner) Run(parent context.Context, state StateBag) {
+	d.l.Lock()
+	if d.state != stateIdle {
+		panic("already running")
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+
+	doneCh := make(chan struct{})
+	d.cancel = cancel
+	d.doneCh = doneCh
+	d.state = stateRunning
+	d.l.Unlock()
+
+	defer func() {
+		d.l.Lock()
+		cancel()
+		d.cancel = nil
+		d.doneCh = nil
+		d.state = stateIdle
+		close(doneCh)
+		d.l.Unlock()
+	}()
+
+	for i, step := range d.Steps {
+		if ctx.Err() != nil {
+			state.Put(StateCancelled, true)
+			return
+		}
+
+		if d.PlanFn != nil {
+			d.PlanFn(i, step)
+		}
+	}
+}
+
+func (d *DryRunner) Cancel() {
+	d.l.Lock()
+	switch d.state {
+	case stateIdle:
+		// Not running, so Cancel is... done.
+		d.l.Unlock()
+		return
+	case stateRunning:
+		// Running, so mark that we cancelled and set the state
+		d.cancel()
+		d.state = stateCancelling
+		fallthrough
+	case stateCancelling:
+		// Already cancelling, so just wait until we're done
+		ch := d.doneCh
+		d.l.Unlock()
+		<-ch
+	}
+}
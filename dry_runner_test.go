@@ -0,0 +1,82 @@
+package multistep
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDryRunner_ImplRunner(t *testing.T) {
+	var raw interface{}
+	raw = &DryRunner{}
+	if _, ok := raw.(Runner); !ok {
+		t.Fatalf("DryRunner must be a Runner")
+	}
+}
+
+func TestDryRunner_Run(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &NamedStep{StepName: "b", Step: &TestStepAcc{Data: "b"}}
+
+	var plan []string
+	r := &DryRunner{
+		Steps: []Step{stepA, stepB},
+		PlanFn: func(index int, step Step) {
+			plan = append(plan, StepName(step))
+		},
+	}
+	r.Run(context.Background(), data)
+
+	expected := []string{"TestStepAcc", "b"}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("unexpected plan: %#v", plan)
+	}
+
+	// No step was run or cleaned up
+	if _, ok := data.GetOk("data"); ok {
+		t.Errorf("steps should not have run")
+	}
+
+	if _, ok := data.GetOk("cleanup"); ok {
+		t.Errorf("steps should not have been cleaned up")
+	}
+}
+
+func TestDryRunner_Cancel(t *testing.T) {
+	data := new(BasicStateBag)
+
+	var plan []int
+	r := &DryRunner{
+		Steps: []Step{&TestStepAcc{}, &TestStepAcc{}, &TestStepAcc{}},
+	}
+	r.PlanFn = func(index int, step Step) {
+		plan = append(plan, index)
+		if index == 0 {
+			// Cancel from another goroutine since Cancel waits for
+			// the run to finish
+			go r.Cancel()
+			for !r.isCancelling() {
+			}
+		}
+	}
+
+	// cancelling an idle Runner is a no-op
+	r.Cancel()
+
+	r.Run(context.Background(), data)
+
+	if !reflect.DeepEqual(plan, []int{0}) {
+		t.Errorf("unexpected plan: %#v", plan)
+	}
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Errorf("cancelled should be in state bag")
+	}
+}
+
+func (d *DryRunner) isCancelling() bool {
+	d.l.Lock()
+	defer d.l.Unlock()
+	return d.state == stateCancelling
+}
@@ -0,0 +1,64 @@
+package multistep
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// EnvStep is a step that seeds the state bag from environment variables,
+// as a first step for workflows configured through the environment.
+//
+// Each variable in Vars that is set, even to "", is put into the bag as a
+// string. If a variable whose key is in Required is unset the step halts,
+// with an error under StateError naming every required variable that is
+// missing. Cleanup does nothing.
+type EnvStep struct {
+	// Vars maps keys in the state bag to the names of the environment
+	// variables to read into them.
+	Vars map[string]string
+
+	// Required lists the keys of Vars that must be set.
+	Required []string
+
+	// LookupEnv, if set, is used in place of os.LookupEnv.
+	LookupEnv func(string) (string, bool)
+}
+
+func (s *EnvStep) Run(_ context.Context, state StateBag) StepAction {
+	lookup := s.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+
+	required := make(map[string]bool, len(s.Required))
+	for _, k := range s.Required {
+		required[k] = true
+	}
+
+	keys := make([]string, 0, len(s.Vars))
+	for k := range s.Vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var missing []string
+	for _, k := range keys {
+		name := s.Vars[k]
+		if v, ok := lookup(name); ok {
+			state.Put(k, v)
+		} else if required[k] {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return Halt(state, fmt.Errorf("multistep: required environment variables not set: %s", strings.Join(missing, ", ")))
+	}
+
+	return ActionContinue
+}
+
+func (s *EnvStep) Cleanup(StateBag) {}
@@ -0,0 +1,65 @@
+package multistep
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEnvStep_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &EnvStep{}
+	if _, ok := raw.(Step); !ok {
+		t.Fatalf("EnvStep must be a Step")
+	}
+}
+
+func TestEnvStep(t *testing.T) {
+	t.Setenv("MULTISTEP_TEST_REGION", "us-east-1")
+	t.Setenv("MULTISTEP_TEST_EMPTY", "")
+
+	data := new(BasicStateBag)
+	step := &EnvStep{
+		Vars: map[string]string{
+			"region":   "MULTISTEP_TEST_REGION",
+			"empty":    "MULTISTEP_TEST_EMPTY",
+			"optional": "MULTISTEP_TEST_UNSET",
+		},
+		Required: []string{"region", "empty"},
+	}
+
+	if action := step.Run(context.Background(), data); action != ActionContinue {
+		t.Fatalf("bad action: %v (%v)", action, GetError(data))
+	}
+
+	if v := data.Get("region"); v != "us-east-1" {
+		t.Fatalf("bad: %#v", v)
+	}
+	if v, ok := data.GetOk("empty"); !ok || v != "" {
+		t.Fatalf("a variable set to empty should be put: %#v", v)
+	}
+	if _, ok := data.GetOk("optional"); ok {
+		t.Fatal("an unset variable should not be put")
+	}
+}
+
+func TestEnvStep_Required(t *testing.T) {
+	env := map[string]string{"B": "b"}
+	step := &EnvStep{
+		Vars:     map[string]string{"a": "A", "b": "B", "c": "C", "d": "D"},
+		Required: []string{"a", "b", "c"},
+		LookupEnv: func(name string) (string, bool) {
+			v, ok := env[name]
+			return v, ok
+		},
+	}
+
+	data := new(BasicStateBag)
+	if action := step.Run(context.Background(), data); action != ActionHalt {
+		t.Fatalf("bad action: %v", action)
+	}
+
+	err := GetError(data)
+	if err == nil || err.Error() != "multistep: required environment variables not set: A, C" {
+		t.Fatalf("bad error: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package multistep
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// The types of Event.
+const (
+	EventStepStart = "step_start"
+	EventStepEnd   = "step_end"
+	EventHalt      = "halt"
+	EventCancel    = "cancel"
+	EventAbort     = "abort"
+	EventComplete  = "complete"
+)
+
+// Event is a single event in the life of a run, as given to an EventSink.
+// It is meant to be serialized, for example to stream a run to a
+// dashboard.
+//
+// Each step that runs has a step_start and a step_end event, even if it
+// returns ActionSkip, and each run ends with exactly one of halt, cancel,
+// abort or complete, before the cleanups.
+type Event struct {
+	// Type is one of the Event constants, such as EventStepStart.
+	Type string `json:"type"`
+
+	// Index is the index into Steps of the step. For the end of a run it
+	// is the last step run, or -1 if none was.
+	Index int `json:"index"`
+
+	// Name is the name of the step, as given by StepName.
+	Name string `json:"name,omitempty"`
+
+	// Action is the action the step returned, for step_end.
+	Action string `json:"action,omitempty"`
+
+	// Error is the error the run stopped with, for halt and cancel.
+	Error string `json:"error,omitempty"`
+
+	// Time is when the event happened.
+	Time time.Time `json:"time"`
+}
+
+// EventSink is given the events of a run, by BasicRunner's EventSink.
+// Emit is called synchronously from the runner, so it should return
+// quickly.
+type EventSink interface {
+	Emit(Event)
+}
+
+// JSONEventSink returns an EventSink that writes each event to w as a
+// JSON object on a line of its own. It is safe for concurrent use. Errors
+// writing to w are ignored, so that they never affect the run.
+func JSONEventSink(w io.Writer) EventSink {
+	return &jsonEventSink{enc: json.NewEncoder(w)}
+}
+
+type jsonEventSink struct {
+	enc *json.Encoder
+	l   sync.Mutex
+}
+
+func (s *jsonEventSink) Emit(e Event) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.enc.Encode(e)
+}
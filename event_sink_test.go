@@ -0,0 +1,79 @@
+package multistep
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An EventSink that records the events, without their times
+type TestEventSink struct {
+	Events []Event
+	l      sync.Mutex
+}
+
+func (s *TestEventSink) Emit(e Event) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	e.Time = time.Time{}
+	s.Events = append(s.Events, e)
+}
+
+func TestBasicRunner_Run_EventSink(t *testing.T) {
+	sink := new(TestEventSink)
+	r := &BasicRunner{
+		Steps:     []Step{&TestStepAcc{Data: "a", Skip: true}, &TestStepAcc{Data: "b", Halt: true}},
+		EventSink: sink,
+	}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	expected := []Event{
+		{Type: EventStepStart, Index: 0, Name: "TestStepAcc"},
+		{Type: EventStepEnd, Index: 0, Name: "TestStepAcc", Action: "ActionSkip"},
+		{Type: EventStepStart, Index: 1, Name: "TestStepAcc"},
+		{Type: EventStepEnd, Index: 1, Name: "TestStepAcc", Action: "ActionHalt"},
+		{Type: EventHalt, Index: 1, Name: "TestStepAcc", Error: "run halted"},
+	}
+	if !reflect.DeepEqual(sink.Events, expected) {
+		t.Fatalf("unexpected events: %#v", sink.Events)
+	}
+}
+
+func TestBasicRunner_Run_EventSink_Cancel(t *testing.T) {
+	sink := new(TestEventSink)
+	r := &BasicRunner{
+		Steps:     []Step{&TestStepAcc{Data: "a"}},
+		EventSink: sink,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Run(ctx, new(BasicStateBag))
+
+	expected := []Event{{Type: EventCancel, Index: -1, Error: "context canceled"}}
+	if !reflect.DeepEqual(sink.Events, expected) {
+		t.Fatalf("unexpected events: %#v", sink.Events)
+	}
+}
+
+func TestJSONEventSink(t *testing.T) {
+	var buf bytes.Buffer
+	r := &BasicRunner{
+		Steps:     []Step{&NamedStep{StepName: "a", Step: &TestStepAcc{Data: "a"}}},
+		EventSink: JSONEventSink(&buf),
+		Clock:     NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
+	}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	expected := `{"type":"step_start","index":0,"name":"a","time":"2024-01-02T03:04:05Z"}
+{"type":"step_end","index":0,"name":"a","action":"ActionContinue","time":"2024-01-02T03:04:05Z"}
+{"type":"complete","index":0,"name":"a","time":"2024-01-02T03:04:05Z"}
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,25 @@
+package multistep
+
+import "context"
+
+// FinalizerStep is a step that does nothing when run, but calls Finalize
+// when cleaned up, much like a defer: for example to always release a lock
+// once the sequence is over, however it ends.
+//
+// A runner only cleans up the steps it reached, so a FinalizerStep should
+// be placed early in the sequence, before any step that could halt, to be
+// sure it is cleaned up. Its Finalize then runs after the cleanups of every
+// later step. Note that with BasicRunner's SkipCleanupOnSuccess set,
+// nothing is cleaned up after a successful run, FinalizerStep included.
+type FinalizerStep struct {
+	// Finalize is called when the step is cleaned up.
+	Finalize func(StateBag)
+}
+
+func (s *FinalizerStep) Run(context.Context, StateBag) StepAction {
+	return ActionContinue
+}
+
+func (s *FinalizerStep) Cleanup(state StateBag) {
+	s.Finalize(state)
+}
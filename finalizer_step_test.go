@@ -0,0 +1,40 @@
+package multistep
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestFinalizerStep_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &FinalizerStep{}
+	if _, ok := raw.(Step); !ok {
+		t.Fatalf("FinalizerStep must be a Step")
+	}
+}
+
+func TestFinalizerStep(t *testing.T) {
+	for _, halt := range []bool{false, true} {
+		data := new(BasicStateBag)
+		step := &FinalizerStep{Finalize: func(state StateBag) {
+			TestStepAcc{Data: "finalize"}.insertData(state, "cleanup")
+		}}
+
+		r := &BasicRunner{Steps: []Step{
+			step,
+			&TestStepAcc{Data: "a"},
+			&TestStepAcc{Data: "b", Halt: halt},
+		}}
+		r.Run(context.Background(), data)
+
+		if _, ok := data.GetOk("data"); !ok {
+			t.Fatalf("halt=%t: later steps should run", halt)
+		}
+
+		results := data.Get("cleanup").([]string)
+		if !reflect.DeepEqual(results, []string{"b", "a", "finalize"}) {
+			t.Fatalf("halt=%t: unexpected result: %#v", halt, results)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package multistep
+
+import "context"
+
+// FuncStep is a Step built from plain functions, for simple steps that
+// don't warrant a type of their own.
+type FuncStep struct {
+	// RunFunc is called to perform the action of the step.
+	RunFunc func(context.Context, StateBag) StepAction
+
+	// CleanupFunc is called to clean up after the step. It is optional;
+	// if it is nil, Cleanup does nothing.
+	CleanupFunc func(StateBag)
+}
+
+func (s *FuncStep) Run(ctx context.Context, state StateBag) StepAction {
+	return s.RunFunc(ctx, state)
+}
+
+func (s *FuncStep) Cleanup(state StateBag) {
+	if s.CleanupFunc != nil {
+		s.CleanupFunc(state)
+	}
+}
@@ -0,0 +1,47 @@
+package multistep
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestFuncStep_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &FuncStep{}
+	if _, ok := raw.(Step); !ok {
+		t.Fatalf("FuncStep must be a Step")
+	}
+}
+
+func TestFuncStep(t *testing.T) {
+	var calls []string
+	step := &FuncStep{
+		RunFunc: func(context.Context, StateBag) StepAction {
+			calls = append(calls, "run")
+			return ActionHalt
+		},
+		CleanupFunc: func(StateBag) {
+			calls = append(calls, "cleanup")
+		},
+	}
+
+	r := &BasicRunner{Steps: []Step{step}}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	expected := []string{"run", "cleanup"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("unexpected calls: %#v", calls)
+	}
+}
+
+func TestFuncStep_NilCleanup(t *testing.T) {
+	step := &FuncStep{
+		RunFunc: func(context.Context, StateBag) StepAction {
+			return ActionContinue
+		},
+	}
+
+	// Should not panic
+	step.Cleanup(new(BasicStateBag))
+}
@@ -0,0 +1,53 @@
+package multistep
+
+import "context"
+
+// IfStep wraps a step so that it only runs if Predicate returns true.
+//
+// Predicate is called each time the step is run, not when the step list
+// is built, so it can depend on anything earlier steps put into the state
+// bag. If it returns false the wrapped step's Run is not called and
+// ActionSkip is returned, so the wrapped step isn't cleaned up either.
+type IfStep struct {
+	// Step is the step to run.
+	Step Step
+
+	// Predicate decides whether the step should run.
+	Predicate func(StateBag) bool
+
+	ran bool
+}
+
+func (s *IfStep) Run(ctx context.Context, state StateBag) StepAction {
+	s.ran = s.Predicate(state)
+	if !s.ran {
+		return ActionSkip
+	}
+
+	return s.Step.Run(ctx, state)
+}
+
+func (s *IfStep) Cleanup(state StateBag) {
+	if s.ran {
+		s.Step.Cleanup(state)
+	}
+}
+
+func (s *IfStep) Unwrap() Step {
+	return s.Step
+}
+
+// ToggleStep returns an IfStep that runs the step unless the state bag
+// holds false under the key flag. The step is enabled if the flag is
+// missing, so an earlier step can switch it off at run time with:
+//
+//	state.Put(flag, false)
+func ToggleStep(step Step, flag string) *IfStep {
+	return &IfStep{
+		Step: step,
+		Predicate: func(state StateBag) bool {
+			enabled, ok := GetBool(state, flag)
+			return enabled || !ok
+		},
+	}
+}
@@ -0,0 +1,96 @@
+package multistep
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestIfStep_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &IfStep{}
+	if _, ok := raw.(Step); !ok {
+		t.Fatalf("IfStep must be a Step")
+	}
+}
+
+func TestIfStep(t *testing.T) {
+	hasArtifact := func(state StateBag) bool {
+		_, ok := state.GetOk("artifact")
+		return ok
+	}
+
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{
+		&IfStep{Step: &TestStepAcc{Data: "a"}, Predicate: hasArtifact},
+		&FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+			state.Put("artifact", true)
+			return ActionContinue
+		}},
+		&IfStep{Step: &TestStepAcc{Data: "b"}, Predicate: hasArtifact},
+	}}
+	r.Run(context.Background(), data)
+
+	// The predicate is evaluated when each step runs
+	expected := []string{"b"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+func TestIfStep_Cleanup(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &IfStep{
+		Step:      &TestStepAcc{Data: "a"},
+		Predicate: func(StateBag) bool { return false },
+	}
+
+	if action := step.Run(context.Background(), data); action != ActionSkip {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	step.Cleanup(data)
+	if _, ok := data.GetOk("cleanup"); ok {
+		t.Errorf("cleanup should not have run")
+	}
+}
+
+func TestToggleStep(t *testing.T) {
+	cases := []struct {
+		Flag     interface{}
+		Expected bool
+	}{
+		{nil, true},
+		{true, true},
+		{false, false},
+	}
+
+	for _, tc := range cases {
+		data := new(BasicStateBag)
+		disable := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+			if tc.Flag != nil {
+				state.Put("feature", tc.Flag)
+			}
+			return ActionContinue
+		}}
+
+		r := &BasicRunner{Steps: []Step{disable, ToggleStep(&TestStepAcc{Data: "a"}, "feature")}}
+		r.Run(context.Background(), data)
+
+		_, ran := data.GetOk("data")
+		if ran != tc.Expected {
+			t.Errorf("flag %#v: bad ran: %t", tc.Flag, ran)
+		}
+
+		_, cleaned := data.GetOk("cleanup")
+		if cleaned != tc.Expected {
+			t.Errorf("flag %#v: bad cleanup: %t", tc.Flag, cleaned)
+		}
+	}
+}
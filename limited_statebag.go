@@ -0,0 +1,142 @@
+package multistep
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrLimitExceeded is wrapped by the errors of a LimitedStateBag that
+// refused a Put.
+var ErrLimitExceeded = errors.New("state bag limit exceeded")
+
+// LimitPolicy is what a LimitedStateBag does with a Put that would go over
+// its limits.
+type LimitPolicy int
+
+const (
+	// LimitReject drops the Put and records an error, for Errors.
+	LimitReject LimitPolicy = iota
+
+	// LimitEvictOldest makes room for a new key by removing the oldest
+	// key put through the bag. A value that is too big is still rejected
+	// like LimitReject, since evicting wouldn't help.
+	LimitEvictOldest
+
+	// LimitPanic panics with the error.
+	LimitPanic
+)
+
+// LimitedStateBag wraps a StateBag with limits on what can be put into
+// it, as a guardrail against a step letting the bag grow without bound in
+// a long-lived process. Reads and Remove pass straight through.
+//
+// Only the keys put through the LimitedStateBag count towards MaxKeys.
+// The well-known keys of this package, such as StateCancelled and
+// StateError, are never limited, so that the runner keeps working.
+//
+// A LimitedStateBag is safe for concurrent use as long as State is.
+type LimitedStateBag struct {
+	// State is the bag to wrap.
+	State StateBag
+
+	// MaxKeys, if more than zero, is the most keys the bag may hold.
+	MaxKeys int
+
+	// MaxSize, if more than zero, is the largest value the bag takes,
+	// as measured by Size. It has no effect without Size.
+	MaxSize int
+
+	// Size returns the size of a value, in whatever unit MaxSize uses.
+	Size func(interface{}) int
+
+	// Policy is what to do with a Put over the limits. By default the
+	// Put is rejected.
+	Policy LimitPolicy
+
+	keys []string
+	errs []error
+	l    sync.Mutex
+}
+
+func (b *LimitedStateBag) Get(k string) interface{} {
+	return b.State.Get(k)
+}
+
+func (b *LimitedStateBag) GetOk(k string) (interface{}, bool) {
+	return b.State.GetOk(k)
+}
+
+func (b *LimitedStateBag) Put(k string, v interface{}) {
+	if globalKeys[k] {
+		b.State.Put(k, v)
+		return
+	}
+
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if b.MaxSize > 0 && b.Size != nil {
+		if size := b.Size(v); size > b.MaxSize {
+			b.exceeded(fmt.Errorf("multistep: %w: value of %q has size %d, more than %d", ErrLimitExceeded, k, size, b.MaxSize))
+			return
+		}
+	}
+
+	if b.index(k) < 0 {
+		if b.MaxKeys > 0 && len(b.keys) >= b.MaxKeys {
+			err := fmt.Errorf("multistep: %w: %q would be more than %d keys", ErrLimitExceeded, k, b.MaxKeys)
+			if b.Policy != LimitEvictOldest {
+				b.exceeded(err)
+				return
+			}
+
+			b.State.Remove(b.keys[0])
+			b.keys = b.keys[1:]
+		}
+
+		b.keys = append(b.keys, k)
+	}
+
+	b.State.Put(k, v)
+}
+
+func (b *LimitedStateBag) Remove(k string) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if i := b.index(k); i >= 0 {
+		b.keys = append(b.keys[:i:i], b.keys[i+1:]...)
+	}
+
+	b.State.Remove(k)
+}
+
+// Errors returns the errors recorded for the Puts that were rejected, in
+// the order they happened.
+func (b *LimitedStateBag) Errors() []error {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	return append([]error(nil), b.errs...)
+}
+
+// exceeded records or panics with err, as the policy says.
+func (b *LimitedStateBag) exceeded(err error) {
+	if b.Policy == LimitPanic {
+		panic(err)
+	}
+
+	b.errs = append(b.errs, err)
+}
+
+// index returns the index of k in the keys put through the bag, or -1.
+func (b *LimitedStateBag) index(k string) int {
+	for i, key := range b.keys {
+		if key == k {
+			return i
+		}
+	}
+
+	return -1
+}
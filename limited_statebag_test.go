@@ -0,0 +1,103 @@
+package multistep
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLimitedStateBag_ImplStateBag(t *testing.T) {
+	var raw interface{}
+	raw = &LimitedStateBag{}
+	if _, ok := raw.(StateBag); !ok {
+		t.Fatalf("must be a StateBag")
+	}
+}
+
+func TestLimitedStateBag_MaxKeys(t *testing.T) {
+	state := new(BasicStateBag)
+	b := &LimitedStateBag{State: state, MaxKeys: 2}
+
+	b.Put("a", 1)
+	b.Put("b", 2)
+	b.Put("a", 3) // replacing a key is fine
+	b.Put("c", 4)
+
+	if keys := state.Keys(); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("bad keys: %#v", keys)
+	}
+	if v := b.Get("a"); v != 3 {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	errs := b.Errors()
+	if len(errs) != 1 || !errors.Is(errs[0], ErrLimitExceeded) {
+		t.Fatalf("bad errors: %#v", errs)
+	}
+
+	// Removing a key makes room
+	b.Remove("b")
+	b.Put("c", 4)
+	if keys := state.Keys(); !reflect.DeepEqual(keys, []string{"a", "c"}) {
+		t.Fatalf("bad keys: %#v", keys)
+	}
+
+	// Well-known keys are never limited
+	b.Put(StateHalted, true)
+	if _, ok := b.GetOk(StateHalted); !ok {
+		t.Fatal("halted should be put")
+	}
+}
+
+func TestLimitedStateBag_EvictOldest(t *testing.T) {
+	state := new(BasicStateBag)
+	b := &LimitedStateBag{State: state, MaxKeys: 2, Policy: LimitEvictOldest}
+
+	b.Put("a", 1)
+	b.Put("b", 2)
+	b.Put("c", 3)
+
+	if keys := state.Keys(); !reflect.DeepEqual(keys, []string{"b", "c"}) {
+		t.Fatalf("bad keys: %#v", keys)
+	}
+	if errs := b.Errors(); len(errs) != 0 {
+		t.Fatalf("bad errors: %#v", errs)
+	}
+}
+
+func TestLimitedStateBag_MaxSize(t *testing.T) {
+	b := &LimitedStateBag{
+		State:   new(BasicStateBag),
+		MaxSize: 3,
+		Size:    func(v interface{}) int { return len(v.(string)) },
+		Policy:  LimitEvictOldest,
+	}
+
+	b.Put("small", "abc")
+	b.Put("big", "abcd")
+
+	if _, ok := b.GetOk("big"); ok {
+		t.Fatal("big value should be rejected")
+	}
+	if v := b.Get("small"); v != "abc" {
+		t.Fatalf("bad: %#v", v)
+	}
+	if errs := b.Errors(); len(errs) != 1 {
+		t.Fatalf("bad errors: %#v", errs)
+	}
+}
+
+func TestLimitedStateBag_Panic(t *testing.T) {
+	b := &LimitedStateBag{State: new(BasicStateBag), MaxKeys: 1, Policy: LimitPanic}
+	b.Put("a", 1)
+
+	defer func() {
+		err, _ := recover().(error)
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("bad panic: %#v", err)
+		}
+	}()
+
+	b.Put("b", 2)
+	t.Fatal("should panic")
+}
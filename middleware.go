@@ -0,0 +1,23 @@
+package multistep
+
+// StepMiddleware wraps a step in another, to apply cross-cutting behavior
+// such as logging or tracing to every step of a runner. The returned step
+// is run and cleaned up in place of the given one.
+//
+// The runner looks up the optional interfaces, such as
+// StepWithCleanupContext, StepWithCleanupGroup or
+// StepWithUninterruptible, on the step that was given, never on the one
+// returned, so middleware doesn't need to forward them. Their cleanup
+// methods are called on the given step directly; middleware only wraps
+// the Run of every step and the plain Cleanup of steps that have no other.
+type StepMiddleware func(Step) Step
+
+// wrapStep wraps the step in the given middleware. The first middleware is
+// the outermost, so it is the first to see each call.
+func wrapStep(step Step, middleware []StepMiddleware) Step {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		step = middleware[i](step)
+	}
+
+	return step
+}
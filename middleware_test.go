@@ -0,0 +1,89 @@
+package multistep
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// testMiddleware returns middleware that records each call under the
+// "calls" key tagged with the given name.
+func testMiddleware(name string) StepMiddleware {
+	return func(next Step) Step {
+		return &FuncStep{
+			RunFunc: func(ctx context.Context, state StateBag) StepAction {
+				appendCall(state, name+" run")
+				return next.Run(ctx, state)
+			},
+			CleanupFunc: func(state StateBag) {
+				appendCall(state, name+" cleanup")
+				next.Cleanup(state)
+			},
+		}
+	}
+}
+
+func appendCall(state StateBag, call string) {
+	calls, _ := state.Get("calls").([]string)
+	state.Put("calls", append(calls, call))
+}
+
+func TestBasicRunner_Use(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}}}
+	r.Use(testMiddleware("outer"), testMiddleware("inner"))
+	r.Run(context.Background(), data)
+
+	expected := []string{"outer run", "inner run", "outer cleanup", "inner cleanup"}
+	results := data.Get("calls").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	// The wrapped step still ran and was cleaned up
+	if !reflect.DeepEqual(data.Get("data"), []string{"a"}) {
+		t.Errorf("unexpected data: %#v", data.Get("data"))
+	}
+
+	if !reflect.DeepEqual(data.Get("cleanup"), []string{"a"}) {
+		t.Errorf("unexpected cleanup: %#v", data.Get("cleanup"))
+	}
+}
+
+func TestBasicRunner_Use_Reconfigure(t *testing.T) {
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}}}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	// Middleware added after a run applies to the next run
+	data := new(BasicStateBag)
+	r.Use(testMiddleware("mw"))
+	r.Run(context.Background(), data)
+
+	expected := []string{"mw run", "mw cleanup"}
+	results := data.Get("calls").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+func TestBasicRunner_Use_CleanupWithContext(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TestStepCleanupContext{TestStepAcc: TestStepAcc{Data: "a"}}
+	r := &BasicRunner{Steps: []Step{step}}
+	r.Use(testMiddleware("outer"))
+	r.Run(context.Background(), data)
+
+	if step.Ctx == nil {
+		t.Fatal("CleanupWithContext should be called through middleware")
+	}
+
+	expected := []string{"outer run"}
+	results := data.Get("calls").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if results := data.Get("cleanup").([]string); !reflect.DeepEqual(results, []string{"a"}) {
+		t.Errorf("unexpected cleanups: %#v", results)
+	}
+}
@@ -4,23 +4,202 @@ package multistep
 
 import (
 	"context"
+	"errors"
+	"fmt"
 )
 
+// ErrUserCancel is the cause given to the context of a run that was
+// cancelled by calling Cancel, as returned by context.Cause. It matches
+// context.Canceled with errors.Is.
+var ErrUserCancel error = userCancelError{}
+
+type userCancelError struct{}
+
+func (userCancelError) Error() string { return "run cancelled" }
+
+func (userCancelError) Is(target error) bool { return target == context.Canceled }
+
+// ErrHalted is the error returned by RunE when a step halted the run
+// without putting an error under StateError.
+var ErrHalted = errors.New("run halted")
+
+// ErrCancelled is the error returned by RunE when the run was cancelled
+// without its context being cancelled, for example by a step setting
+// StateCancelled itself.
+var ErrCancelled = errors.New("run cancelled by a step")
+
+// ErrAborted is the error returned by RunE when a step aborted the run
+// without putting an error under StateError.
+var ErrAborted = errors.New("run aborted")
+
 // A StepAction determines the next step to take regarding multi-step actions.
 type StepAction uint
 
 const (
 	ActionContinue StepAction = iota
 	ActionHalt
+
+	// ActionSkip continues the sequence like ActionContinue, but signals
+	// that the step did no work, so its Cleanup is not called.
+	ActionSkip
+
+	// ActionReplay runs the same step again instead of moving on, for a
+	// step that fixed something up in the state bag and wants another go.
+	// The step's Cleanup is still only called once, however many times it
+	// was run. See BasicRunner's MaxReplays.
+	ActionReplay
+
+	// ActionAbort stops the run like ActionHalt, but also skips the
+	// cleanups of every step, including the ones that already ran, and
+	// sets StateAborted. Whatever those steps created is left behind, so
+	// this is only for a state so broken that cleaning up would do more
+	// harm than good. Prefer ActionHalt in every other case.
+	ActionAbort
 )
 
+func (a StepAction) String() string {
+	switch a {
+	case ActionContinue:
+		return "ActionContinue"
+	case ActionHalt:
+		return "ActionHalt"
+	case ActionSkip:
+		return "ActionSkip"
+	case ActionReplay:
+		return "ActionReplay"
+	case ActionAbort:
+		return "ActionAbort"
+	}
+
+	return fmt.Sprintf("StepAction(%d)", uint(a))
+}
+
 // This is the key set in the state bag when using the basic runner to
 // signal that the step sequence was cancelled.
+//
+// When the runner cancels a run, the context given to the steps is always
+// cancelled before the runner sets this key, so a step watching ctx.Done()
+// hears about the cancel no later than one checking the state bag. A step
+// that sets the key itself doesn't cancel the context.
 const StateCancelled = "cancelled"
 
 // This is the key set in the state bag when a step halted the sequence.
 const StateHalted = "halted"
 
+// This is the key set in the state bag, along with StateHalted, when a
+// step aborted the sequence with ActionAbort. No cleanups are run after
+// it is set.
+const StateAborted = "aborted"
+
+// This is the key under which a step that halts the sequence can store an
+// error explaining why.
+const StateError = "error"
+
+// StateHaltReason is the key under which Halt stores the error explaining
+// why a step halted. It is the same key as StateError, so that halts from
+// Halt and from the wrappers in this package are all found in one place.
+const StateHaltReason = StateError
+
+// This is the key under which the basic runner stores a []StepTiming when
+// RecordTimings is set.
+const StateStepTimings = "step_timings"
+
+// This is the key under which the basic runner stores a []StepResult
+// recording how each step whose Run was called ended, in the order they
+// ran, including steps that returned ActionSkip.
+const StateStepResults = "step_results"
+
+// This is the key under which the basic runner stores a []error holding
+// the errors from cleaning up the steps, in the order the cleanups ran.
+const StateCleanupErrors = "cleanup_errors"
+
+// This is the key under which the basic runner stores a []StepTiming of
+// the cleanups when RecordCleanupTimings is set.
+const StateCleanupTimings = "cleanup_timings"
+
+// This is the key under which the basic runner stores the time.Duration of
+// all the cleanups together when RecordCleanupTimings is set.
+const StateCleanupDuration = "cleanup_duration"
+
+// This is the key under which the basic runner stores an []int of the
+// indexes of the steps whose cleanups finished after its CleanupDeadline.
+const StateCleanupOverdue = "cleanup_overdue"
+
+// This is the key set in the state bag, when the basic runner's
+// ForceFullCleanup is cleaning up after a halt or cancel, to a bool saying
+// whether the Run of the step being cleaned up was called.
+const StateStepRan = "step_ran"
+
+// This is the key under which a step can put a []Step to add to the run.
+// The basic runner removes the key after each step and runs the steps it
+// held straight after that step, before the rest of the sequence, in the
+// order given. They are cleaned up like any other step. Steps added by a
+// step that halts are not run. As with Steps, none of them may be nil, or
+// the runner panics.
+const StateNextSteps = "next_steps"
+
+// This is the key under which the basic runner stores a []StepFailure of
+// the steps that halted when ContinueOnHalt is set.
+const StateFailures = "failures"
+
+// This is the key under which the basic runner stores a string that
+// identifies the run, a random UUID, for correlating logs and API calls.
+// A run ID that is already in the state bag is kept.
+const StateRunID = "run_id"
+
+// This is the key under which the basic runner stores a copy of its
+// Metadata, a map[string]interface{}, at the start of each run.
+const StateMetadata = "metadata"
+
+// This is the key under which a step can put a time.Time by which the
+// rest of the run should be done, for example when it learns that a lease
+// expires. The basic runner gives each later step a context with that
+// deadline, or its own deadline if that is earlier. Deadlines can only
+// tighten: a later deadline put under the key is ignored.
+const StateDeadline = "deadline"
+
+// Halt stores err in the state bag under StateHaltReason and returns
+// ActionHalt, so that a step can halt with a reason in one line:
+//
+//	return multistep.Halt(state, err)
+func Halt(state StateBag, err error) StepAction {
+	state.Put(StateHaltReason, err)
+	return ActionHalt
+}
+
+// HaltOnError halts with err, exactly like Halt, if err is not nil, and
+// returns ActionContinue otherwise:
+//
+//	return multistep.HaltOnError(state, doSomething())
+func HaltOnError(state StateBag, err error) StepAction {
+	if err != nil {
+		return Halt(state, err)
+	}
+
+	return ActionContinue
+}
+
+// GetError returns the error stored in the state bag under StateError, or
+// nil if there isn't one.
+func GetError(state StateBag) error {
+	err, _ := state.Get(StateError).(error)
+	return err
+}
+
+// Metadata returns the metadata of the run stored under StateMetadata, or
+// nil if there is none.
+func Metadata(state StateBag) map[string]interface{} {
+	metadata, _ := state.Get(StateMetadata).(map[string]interface{})
+	return metadata
+}
+
+// RunID returns the ID of the run stored under StateRunID, or "" if there
+// is none.
+func RunID(state StateBag) string {
+	id, _ := state.Get(StateRunID).(string)
+	return id
+}
+
 // Step is a single step that is part of a potentially large sequence
 // of other steps, responsible for performing some specific action.
 type Step interface {
@@ -30,6 +209,10 @@ type Step interface {
 	//
 	// The return value determines whether multi-step sequences continue
 	// or should halt.
+	//
+	// The context is cancelled when the sequence is cancelled. A step can
+	// be cancelled at any point while it runs, so long running steps
+	// should watch ctx.Done() and return promptly once it is closed.
 	Run(context.Context, StateBag) StepAction
 
 	// Cleanup is called in reverse order of the steps that have run
@@ -42,6 +225,116 @@ type Step interface {
 	Cleanup(StateBag)
 }
 
+// StepWithOutcome is an interface that steps can implement to be told how
+// the run ended when they are cleaned up. Runners that support it call
+// CleanupWithOutcome instead of Cleanup.
+type StepWithOutcome interface {
+	Step
+
+	// CleanupWithOutcome is called in place of Cleanup. The action is
+	// ActionContinue if the run completed, or ActionHalt if it was halted
+	// or cancelled. StateCancelled tells the two apart.
+	CleanupWithOutcome(StateBag, StepAction)
+}
+
+// StepWithCleanupError is an interface that steps can implement to report
+// that cleaning up failed. Runners that support it call CleanupWithError
+// instead of Cleanup (or CleanupWithOutcome).
+type StepWithCleanupError interface {
+	Step
+
+	// CleanupWithError is called in place of Cleanup, and returns an
+	// error if the cleanup failed.
+	CleanupWithError(StateBag) error
+}
+
+// Validatable is an interface that steps can implement to check their
+// configuration before anything is run, so that mistakes are caught
+// before a sequence is partway through.
+type Validatable interface {
+	// Validate returns an error if the step can't be run with the given
+	// state. It must not have any side effects.
+	Validate(StateBag) error
+}
+
+// StepWithCleanupContext is an interface that steps can implement to be
+// given a context when they are cleaned up, for example to call an API
+// with a deadline. Runners that support it call CleanupWithContext instead
+// of any other cleanup method.
+type StepWithCleanupContext interface {
+	Step
+
+	// CleanupWithContext is called in place of Cleanup. The context
+	// carries the values of the context the step was run with, but it is
+	// not cancelled when the run is, so that cleaning up can finish after
+	// a cancel.
+	CleanupWithContext(context.Context, StateBag)
+}
+
+// StepWithCleanupContextError is an interface that steps can implement to
+// be given a context when they are cleaned up, like StepWithCleanupContext,
+// and to report that cleaning up failed, like StepWithCleanupError. The
+// basic runner retries a failed cleanup as its CleanupRetry says. Runners
+// that support it call CleanupWithContextError instead of any other
+// cleanup method.
+type StepWithCleanupContextError interface {
+	Step
+
+	// CleanupWithContextError is called in place of Cleanup, with a
+	// context like CleanupWithContext's, and returns an error if the
+	// cleanup failed.
+	CleanupWithContextError(context.Context, StateBag) error
+}
+
+// StepWithCleanupGroup is an interface that steps can implement to have
+// their cleanups run concurrently with those of other steps, for example
+// to delete independent resources in parallel.
+//
+// Runners that support it clean up one group at a time, starting with the
+// highest numbered group. The steps in a group are cleaned up at the same
+// time, except for group 0, the default for steps that don't implement
+// this interface, whose steps are cleaned up one at a time in reverse
+// order as usual.
+type StepWithCleanupGroup interface {
+	Step
+
+	// CleanupGroup returns the cleanup group of the step.
+	CleanupGroup() int
+}
+
+// StepWithCleanupOnHalt is an interface that steps can implement to say
+// whether they should be cleaned up after they halt the sequence
+// themselves, for example because there is nothing to clean up when the
+// step failed to acquire its resource. Steps that don't implement it are
+// always cleaned up.
+type StepWithCleanupOnHalt interface {
+	Step
+
+	// CleanupOnHalt returns false if the step should not be cleaned up
+	// after its Run returned ActionHalt.
+	CleanupOnHalt() bool
+}
+
+// StepWithUninterruptible is an interface that steps can implement to say
+// that they must not be cancelled part way through, for example because
+// they make a single transactional API call that is worse half done than
+// done.
+//
+// The basic runner runs such a step with a context that is not cancelled
+// when the run is, nor when its Timeout passes, although it still carries
+// the values of the run's context. A cancel therefore only takes effect
+// once the step returns: the runner then flags StateCancelled, if it
+// hasn't already, and stops before the next step as usual. Cancel blocks
+// until then, however long the step takes, and CancelGrace doesn't apply.
+// Steps that don't implement it can be interrupted.
+type StepWithUninterruptible interface {
+	Step
+
+	// Uninterruptible returns true if the step must not be cancelled
+	// while it is running.
+	Uninterruptible() bool
+}
+
 // Runner is a thing that runs one or more steps.
 type Runner interface {
 	// Run runs the steps with the given initial state.
@@ -50,3 +343,13 @@ type Runner interface {
 	// Cancel cancels a potentially running stack of steps.
 	Cancel()
 }
+
+// RunnerE is a Runner that can also report how a run ended as an error.
+type RunnerE interface {
+	Runner
+
+	// RunE runs the steps like Run. It returns nil if the run completed,
+	// the reason for the halt if a step halted it, or the cause of the
+	// cancellation if it was cancelled.
+	RunE(context.Context, StateBag) error
+}
@@ -1,6 +1,13 @@
 package multistep
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
 
 // A step for testing that accumuluates data into a string slice in the
 // the state bag. It always uses the "data" key in the state bag, and will
@@ -11,6 +18,12 @@ type TestStepAcc struct {
 
 	// If true, it will halt at the step when it is run
 	Halt bool
+
+	// If true, it will skip at the step when it is run
+	Skip bool
+
+	// If true, it will abort at the step when it is run
+	Abort bool
 }
 
 // A step that syncs by sending a channel and expecting a response.
@@ -33,6 +46,14 @@ func (s TestStepAcc) Run(_ context.Context, state StateBag) StepAction {
 		return ActionHalt
 	}
 
+	if s.Abort {
+		return ActionAbort
+	}
+
+	if s.Skip {
+		return ActionSkip
+	}
+
 	return ActionContinue
 }
 
@@ -69,8 +90,329 @@ func (s TestStepWaitForever) Cleanup(StateBag) {}
 
 func (s TestStepInjectCancel) Run(_ context.Context, state StateBag) StepAction {
 	r := state.Get("runner").(*BasicRunner)
-	r.state = stateCancelling
+	r.setState(stateCancelling)
 	return ActionContinue
 }
 
 func (s TestStepInjectCancel) Cleanup(StateBag) {}
+
+// A step that blocks until its context is cancelled
+type TestStepWaitCancel struct {
+	// If set, it is closed once the step is running
+	Started chan struct{}
+
+	CleanedUp bool
+}
+
+func (s *TestStepWaitCancel) Run(ctx context.Context, _ StateBag) StepAction {
+	if s.Started != nil {
+		close(s.Started)
+	}
+
+	<-ctx.Done()
+	return ActionContinue
+}
+
+func (s *TestStepWaitCancel) Cleanup(StateBag) {
+	s.CleanedUp = true
+}
+
+// waitForKey polls the state bag until the given key is present.
+func waitForKey(state StateBag, key string) {
+	for {
+		if _, ok := state.GetOk(key); ok {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// An observer that records the events it sees
+type TestObserver struct {
+	Events []string
+}
+
+func (o *TestObserver) StepStart(index int, _ Step, _ StateBag) {
+	o.Events = append(o.Events, fmt.Sprintf("start %d", index))
+}
+
+func (o *TestObserver) StepEnd(index int, _ Step, action StepAction, _ StateBag) {
+	o.Events = append(o.Events, fmt.Sprintf("end %d %d", index, action))
+}
+
+func (o *TestObserver) Progress(completed, total int) {
+	o.Events = append(o.Events, fmt.Sprintf("progress %d/%d", completed, total))
+}
+
+// A step that reports an inner step name like a wrapper would
+type TestStepWrapped struct {
+	Name string
+}
+
+func (s *TestStepWrapped) Run(context.Context, StateBag) StepAction {
+	return ActionContinue
+}
+
+func (s *TestStepWrapped) Cleanup(StateBag) {}
+
+func (s *TestStepWrapped) InnerStepName() string {
+	return s.Name
+}
+
+// A step that records the outcome it is cleaned up with
+type TestStepOutcome struct {
+	TestStepAcc
+
+	Outcomes []StepAction
+}
+
+func (s *TestStepOutcome) CleanupWithOutcome(_ StateBag, action StepAction) {
+	s.Outcomes = append(s.Outcomes, action)
+}
+
+// A step whose cleanup fails with the given error, or panics if Panic is set
+type TestStepCleanupError struct {
+	TestStepAcc
+
+	Err   error
+	Panic bool
+}
+
+func (s *TestStepCleanupError) CleanupWithError(state StateBag) error {
+	s.insertData(state, "cleanup")
+	if s.Panic {
+		panic(s.Err)
+	}
+
+	return s.Err
+}
+
+func TestStepAction_String(t *testing.T) {
+	// Every defined action must be listed here
+	actions := []StepAction{ActionContinue, ActionHalt, ActionSkip, ActionReplay, ActionAbort}
+
+	seen := make(map[string]bool)
+	for _, a := range actions {
+		s := a.String()
+		if s == "" || seen[s] {
+			t.Errorf("%d: bad string %q", a, s)
+		}
+		seen[s] = true
+	}
+
+	if s := StepAction(100).String(); s != "StepAction(100)" {
+		t.Errorf("bad string for unknown action: %q", s)
+	}
+}
+
+// A step that fails validation with the given error
+type TestStepValidate struct {
+	TestStepAcc
+
+	Err error
+}
+
+func (s *TestStepValidate) Validate(StateBag) error {
+	return s.Err
+}
+
+func TestHalt(t *testing.T) {
+	errOops := errors.New("oops")
+	data := new(BasicStateBag)
+	step := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		return Halt(state, errOops)
+	}}
+
+	r := &BasicRunner{Steps: []Step{step}}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Errorf("halted should be in state bag")
+	}
+
+	if err := data.Get(StateHaltReason); err != errOops {
+		t.Errorf("bad halt reason: %#v", err)
+	}
+}
+
+func TestHaltOnError(t *testing.T) {
+	data := new(BasicStateBag)
+	if action := HaltOnError(data, nil); action != ActionContinue {
+		t.Errorf("bad action: %s", action)
+	}
+
+	if err := GetError(data); err != nil {
+		t.Errorf("should not have an error: %#v", err)
+	}
+
+	errOops := errors.New("oops")
+	if action := HaltOnError(data, errOops); action != ActionHalt {
+		t.Errorf("bad action: %s", action)
+	}
+
+	if err := GetError(data); err != errOops {
+		t.Errorf("bad error: %#v", err)
+	}
+}
+
+func TestGetError_NotAnError(t *testing.T) {
+	data := new(BasicStateBag)
+	data.Put(StateError, "oops")
+
+	if err := GetError(data); err != nil {
+		t.Errorf("should not have an error: %#v", err)
+	}
+}
+
+func TestRunID(t *testing.T) {
+	data := new(BasicStateBag)
+	if id := RunID(data); id != "" {
+		t.Fatalf("bad: %q", id)
+	}
+
+	data.Put(StateRunID, "abc")
+	if id := RunID(data); id != "abc" {
+		t.Fatalf("bad: %q", id)
+	}
+}
+
+// A step that records the context it is cleaned up with
+type TestStepCleanupContext struct {
+	TestStepAcc
+
+	Ctx context.Context
+}
+
+func (s *TestStepCleanupContext) CleanupWithContext(ctx context.Context, state StateBag) {
+	s.Ctx = ctx
+	s.insertData(state, "cleanup")
+}
+
+// A step whose cleanup waits for its context to be done, recording the
+// cause
+type TestStepCleanupWait struct {
+	TestStepAcc
+
+	Cause error
+}
+
+func (s *TestStepCleanupWait) CleanupWithContext(ctx context.Context, state StateBag) {
+	<-ctx.Done()
+	s.Cause = context.Cause(ctx)
+	s.insertData(state, "cleanup")
+}
+
+// An uninterruptible step that waits for Release, recording the error of
+// its context once released
+type TestStepUninterruptible struct {
+	TestStepAcc
+
+	Started chan struct{}
+	Release chan struct{}
+	CtxErr  error
+}
+
+func (s *TestStepUninterruptible) Run(ctx context.Context, state StateBag) StepAction {
+	close(s.Started)
+	<-s.Release
+	s.CtxErr = ctx.Err()
+	return s.TestStepAcc.Run(ctx, state)
+}
+
+func (s *TestStepUninterruptible) Uninterruptible() bool {
+	return true
+}
+
+// A step whose cleanup fails with Errs, one per attempt, then succeeds
+type TestStepCleanupRetry struct {
+	TestStepAcc
+
+	Errs     []error
+	Attempts int
+	Ctx      context.Context
+}
+
+func (s *TestStepCleanupRetry) CleanupWithContextError(ctx context.Context, state StateBag) error {
+	s.Ctx = ctx
+	s.Attempts++
+	if s.Attempts <= len(s.Errs) {
+		return s.Errs[s.Attempts-1]
+	}
+
+	s.insertData(state, "cleanup")
+	return nil
+}
+
+// A step in the given cleanup group, whose cleanup waits on Wait if set
+type TestStepCleanupGroup struct {
+	Data  string
+	Group int
+	Wait  *sync.WaitGroup
+
+	l *sync.Mutex
+}
+
+func (s TestStepCleanupGroup) Run(context.Context, StateBag) StepAction {
+	return ActionContinue
+}
+
+func (s TestStepCleanupGroup) Cleanup(state StateBag) {
+	if s.Wait != nil {
+		// Every step in the group must be cleaning up at once for this
+		// to return.
+		s.Wait.Done()
+		s.Wait.Wait()
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	TestStepAcc{Data: s.Data}.insertData(state, "cleanup")
+}
+
+func (s TestStepCleanupGroup) CleanupGroup() int {
+	return s.Group
+}
+
+// A step that can opt out of being cleaned up when it halts
+type TestStepCleanupOnHalt struct {
+	TestStepAcc
+
+	OnHalt bool
+}
+
+func (s TestStepCleanupOnHalt) CleanupOnHalt() bool {
+	return s.OnHalt
+}
+
+// A step with the given weight
+type TestStepWeighted struct {
+	TestStepAcc
+
+	W float64
+}
+
+func (s TestStepWeighted) Weight() float64 {
+	return s.W
+}
+
+// A progress reporter that also records weighted progress
+type TestWeightedProgress struct {
+	TestObserver
+}
+
+func (p *TestWeightedProgress) WeightedProgress(completed, total float64) {
+	p.Events = append(p.Events, fmt.Sprintf("weighted %g/%g", completed, total))
+}
+
+func TestMetadata(t *testing.T) {
+	data := new(BasicStateBag)
+	if m := Metadata(data); m != nil {
+		t.Fatalf("bad: %#v", m)
+	}
+
+	data.Put(StateMetadata, map[string]interface{}{"env": "staging"})
+	if m := Metadata(data); m["env"] != "staging" {
+		t.Fatalf("bad: %#v", m)
+	}
+}
@@ -0,0 +1,159 @@
+// Package multisteptest provides helpers for testing runners, steps and
+// middleware built with multistep.
+package multisteptest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matt-e/multistep"
+)
+
+// Call is a single call to a RecordingStep.
+type Call struct {
+	// Name is the name of the step that was called.
+	Name string
+
+	// Method is "Run" or "Cleanup".
+	Method string
+}
+
+// Recorder records the calls made to a set of RecordingSteps, in the order
+// they were made. It is safe for concurrent use.
+type Recorder struct {
+	calls []Call
+	l     sync.Mutex
+}
+
+func (r *Recorder) record(name, method string) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.calls = append(r.calls, Call{Name: name, Method: method})
+}
+
+// Calls returns every call recorded so far, in the order they were made.
+func (r *Recorder) Calls() []Call {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	return append([]Call(nil), r.calls...)
+}
+
+// Runs returns the names of the steps that were run, in the order they
+// were run.
+func (r *Recorder) Runs() []string {
+	return r.names("Run")
+}
+
+// Cleanups returns the names of the steps that were cleaned up, in the
+// order they were cleaned up.
+func (r *Recorder) Cleanups() []string {
+	return r.names("Cleanup")
+}
+
+func (r *Recorder) names(method string) []string {
+	var result []string
+	for _, c := range r.Calls() {
+		if c.Method == method {
+			result = append(result, c.Name)
+		}
+	}
+
+	return result
+}
+
+// RecordingStep is a step for tests that returns a fixed action, and
+// counts and records the calls made to it.
+type RecordingStep struct {
+	// StepName is the name of the step, used in the Recorder and returned
+	// by Name.
+	StepName string
+
+	// Action is the action Run returns.
+	Action multistep.StepAction
+
+	// Delay, if non-zero, is how long Run takes. Run returns early, with
+	// Action, if its context is cancelled.
+	Delay time.Duration
+
+	// Recorder, if set, records the calls made to the step.
+	Recorder *Recorder
+
+	runs     int
+	cleanups int
+	l        sync.Mutex
+}
+
+func (s *RecordingStep) Name() string {
+	return s.StepName
+}
+
+func (s *RecordingStep) Run(ctx context.Context, _ multistep.StateBag) multistep.StepAction {
+	s.l.Lock()
+	s.runs++
+	s.l.Unlock()
+
+	if s.Recorder != nil {
+		s.Recorder.record(s.StepName, "Run")
+	}
+
+	if s.Delay > 0 {
+		timer := time.NewTimer(s.Delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+
+	return s.Action
+}
+
+func (s *RecordingStep) Cleanup(multistep.StateBag) {
+	s.l.Lock()
+	s.cleanups++
+	s.l.Unlock()
+
+	if s.Recorder != nil {
+		s.Recorder.record(s.StepName, "Cleanup")
+	}
+}
+
+// RunCount returns how many times Run was called.
+func (s *RecordingStep) RunCount() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.runs
+}
+
+// CleanupCount returns how many times Cleanup was called.
+func (s *RecordingStep) CleanupCount() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.cleanups
+}
+
+// AssertCleanupOrder fails the test unless the steps recorded by r were
+// cleaned up in exactly the order given by names.
+func AssertCleanupOrder(t testing.TB, r *Recorder, names ...string) {
+	t.Helper()
+
+	actual := r.Cleanups()
+	if len(actual) != len(names) {
+		t.Errorf("bad cleanup order: got %q, want %q", actual, names)
+		return
+	}
+
+	for i := range names {
+		if actual[i] != names[i] {
+			t.Errorf("bad cleanup order: got %q, want %q", actual, names)
+			return
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package multisteptest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matt-e/multistep"
+)
+
+func TestRecordingStep_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &RecordingStep{}
+	if _, ok := raw.(multistep.Step); !ok {
+		t.Fatalf("RecordingStep must be a Step")
+	}
+}
+
+func TestRecordingStep(t *testing.T) {
+	rec := new(Recorder)
+	a := &RecordingStep{StepName: "a", Recorder: rec}
+	b := &RecordingStep{StepName: "b", Recorder: rec, Action: multistep.ActionHalt}
+	c := &RecordingStep{StepName: "c", Recorder: rec}
+
+	r := &multistep.BasicRunner{Steps: []multistep.Step{a, b, c}}
+	r.Run(context.Background(), new(multistep.BasicStateBag))
+
+	expected := []Call{
+		{"a", "Run"},
+		{"b", "Run"},
+		{"b", "Cleanup"},
+		{"a", "Cleanup"},
+	}
+	if calls := rec.Calls(); !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("bad calls: %#v", calls)
+	}
+
+	if !reflect.DeepEqual(rec.Runs(), []string{"a", "b"}) {
+		t.Fatalf("bad runs: %#v", rec.Runs())
+	}
+
+	AssertCleanupOrder(t, rec, "b", "a")
+
+	if a.RunCount() != 1 || a.CleanupCount() != 1 {
+		t.Errorf("bad counts for a: %d, %d", a.RunCount(), a.CleanupCount())
+	}
+
+	if c.RunCount() != 0 || c.CleanupCount() != 0 {
+		t.Errorf("bad counts for c: %d, %d", c.RunCount(), c.CleanupCount())
+	}
+}
+
+func TestRecordingStep_Delay(t *testing.T) {
+	step := &RecordingStep{StepName: "slow", Delay: time.Minute}
+	r := &multistep.BasicRunner{Steps: []multistep.Step{step}}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), new(multistep.BasicStateBag))
+		close(doneCh)
+	}()
+
+	for step.RunCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	r.Cancel()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("delay should end when the run is cancelled")
+	}
+}
+
+// A testing.TB that only records whether the test failed
+type fakeT struct {
+	testing.TB
+
+	failed bool
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(string, ...interface{}) {
+	t.failed = true
+}
+
+func TestAssertCleanupOrder(t *testing.T) {
+	rec := new(Recorder)
+	rec.record("a", "Cleanup")
+	rec.record("b", "Cleanup")
+
+	ft := new(fakeT)
+	AssertCleanupOrder(ft, rec, "b", "a")
+	if !ft.failed {
+		t.Fatal("should fail on the wrong order")
+	}
+
+	ft = new(fakeT)
+	AssertCleanupOrder(ft, rec, "a", "b")
+	if ft.failed {
+		t.Fatal("should pass on the right order")
+	}
+}
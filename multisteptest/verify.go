@@ -0,0 +1,225 @@
+package multisteptest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matt-e/multistep"
+)
+
+// VerifyRunner checks that the runners made by newRunner keep the contract
+// of BasicRunner, for the authors of other runners: the steps run in
+// order, a halt stops the run and flags StateHalted, a cancel flags
+// StateCancelled and stops the run, and the steps that ran are each
+// cleaned up once, in reverse order. newRunner must return a new runner
+// that runs the given steps each time it is called.
+//
+// It runs each check as a subtest of t.
+func VerifyRunner(t *testing.T, newRunner func(steps []multistep.Step) multistep.Runner) {
+	steps := func(rec *Recorder, actions ...multistep.StepAction) ([]multistep.Step, []*RecordingStep) {
+		var result []multistep.Step
+		var recording []*RecordingStep
+		for i, action := range actions {
+			step := &RecordingStep{StepName: fmt.Sprintf("step%d", i), Action: action, Recorder: rec}
+			result = append(result, step)
+			recording = append(recording, step)
+		}
+
+		return result, recording
+	}
+
+	checkCounts := func(t *testing.T, recording []*RecordingStep) {
+		t.Helper()
+		for _, s := range recording {
+			if s.CleanupCount() > 1 {
+				t.Errorf("%s cleaned up %d times", s.StepName, s.CleanupCount())
+			}
+		}
+	}
+
+	t.Run("Completed", func(t *testing.T) {
+		rec := new(Recorder)
+		s, recording := steps(rec, multistep.ActionContinue, multistep.ActionContinue, multistep.ActionContinue)
+		state := new(multistep.BasicStateBag)
+		newRunner(s).Run(context.Background(), state)
+
+		assertNames(t, "runs", rec.Runs(), "step0", "step1", "step2")
+		AssertCleanupOrder(t, rec, "step2", "step1", "step0")
+		checkCounts(t, recording)
+
+		for _, k := range []string{multistep.StateHalted, multistep.StateCancelled} {
+			if _, ok := state.GetOk(k); ok {
+				t.Errorf("%s should not be in the state bag", k)
+			}
+		}
+	})
+
+	t.Run("Halted", func(t *testing.T) {
+		rec := new(Recorder)
+		s, recording := steps(rec, multistep.ActionContinue, multistep.ActionHalt, multistep.ActionContinue)
+		state := new(multistep.BasicStateBag)
+		newRunner(s).Run(context.Background(), state)
+
+		assertNames(t, "runs", rec.Runs(), "step0", "step1")
+		AssertCleanupOrder(t, rec, "step1", "step0")
+		checkCounts(t, recording)
+
+		if _, ok := state.GetOk(multistep.StateHalted); !ok {
+			t.Errorf("%s should be in the state bag", multistep.StateHalted)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		rec := new(Recorder)
+		s, recording := steps(rec, multistep.ActionContinue, multistep.ActionContinue, multistep.ActionContinue)
+		recording[1].Delay = time.Hour
+
+		state := new(multistep.BasicStateBag)
+		r := newRunner(s)
+		doneCh := make(chan struct{})
+		go func() {
+			defer close(doneCh)
+			r.Run(context.Background(), state)
+		}()
+
+		if !waitFor(func() bool { return recording[1].RunCount() > 0 }) {
+			t.Fatal("second step never ran")
+		}
+		r.Cancel()
+
+		select {
+		case <-doneCh:
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not finish after Cancel")
+		}
+
+		if recording[2].RunCount() != 0 {
+			t.Error("steps after the cancel should not run")
+		}
+		checkCounts(t, recording)
+
+		if _, ok := state.GetOk(multistep.StateCancelled); !ok {
+			t.Errorf("%s should be in the state bag", multistep.StateCancelled)
+		}
+		if recording[0].CleanupCount() != 1 {
+			t.Error("steps that ran should be cleaned up")
+		}
+	})
+
+	t.Run("ContextCancelled", func(t *testing.T) {
+		rec := new(Recorder)
+		s, _ := steps(rec, multistep.ActionContinue)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		state := new(multistep.BasicStateBag)
+		newRunner(s).Run(ctx, state)
+
+		if _, ok := state.GetOk(multistep.StateCancelled); !ok {
+			t.Errorf("%s should be in the state bag", multistep.StateCancelled)
+		}
+	})
+}
+
+// VerifyStateBag checks that the bags made by newBag keep the contract of
+// StateBag: values put can be got back, GetOk tells a missing key from a
+// nil value, Remove removes, and the bag is safe for concurrent use. It
+// is best run with -race. newBag must return a new, empty bag each time it
+// is called.
+//
+// It runs each check as a subtest of t.
+func VerifyStateBag(t *testing.T, newBag func() multistep.StateBag) {
+	t.Run("Missing", func(t *testing.T) {
+		b := newBag()
+		if v := b.Get("missing"); v != nil {
+			t.Errorf("Get of a missing key: got %#v, want nil", v)
+		}
+		if _, ok := b.GetOk("missing"); ok {
+			t.Error("GetOk of a missing key should return false")
+		}
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		b := newBag()
+		b.Put("key", "a")
+		if v, ok := b.GetOk("key"); !ok || v != "a" {
+			t.Errorf("GetOk after Put: got %#v, %t", v, ok)
+		}
+
+		b.Put("key", "b")
+		if v := b.Get("key"); v != "b" {
+			t.Errorf("Get after a second Put: got %#v", v)
+		}
+
+		b.Put("nil", nil)
+		if v, ok := b.GetOk("nil"); !ok || v != nil {
+			t.Errorf("GetOk of a nil value: got %#v, %t", v, ok)
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		b := newBag()
+		b.Put("key", "a")
+		b.Remove("key")
+		if _, ok := b.GetOk("key"); ok {
+			t.Error("GetOk after Remove should return false")
+		}
+
+		// Removing a missing key is fine
+		b.Remove("missing")
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		b := newBag()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				k := fmt.Sprintf("key%d", i)
+				for j := 0; j < 100; j++ {
+					b.Put(k, j)
+					b.Get(k)
+					b.GetOk("shared")
+					b.Put("shared", j)
+				}
+				b.Remove(k)
+			}(i)
+		}
+		wg.Wait()
+
+		for i := 0; i < 8; i++ {
+			if _, ok := b.GetOk(fmt.Sprintf("key%d", i)); ok {
+				t.Errorf("key%d should be removed", i)
+			}
+		}
+	})
+}
+
+// assertNames fails the test unless actual is exactly names.
+func assertNames(t testing.TB, what string, actual []string, names ...string) {
+	t.Helper()
+
+	if fmt.Sprint(actual) != fmt.Sprint(names) {
+		t.Errorf("bad %s: got %q, want %q", what, actual, names)
+	}
+}
+
+// waitFor polls f until it returns true, for up to five seconds, and
+// returns whether it did.
+func waitFor(f func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	return true
+}
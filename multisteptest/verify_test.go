@@ -0,0 +1,42 @@
+package multisteptest
+
+import (
+	"testing"
+
+	"github.com/matt-e/multistep"
+)
+
+func TestVerifyRunner(t *testing.T) {
+	t.Run("BasicRunner", func(t *testing.T) {
+		VerifyRunner(t, func(steps []multistep.Step) multistep.Runner {
+			return &multistep.BasicRunner{Steps: steps}
+		})
+	})
+
+	t.Run("SequenceRunner", func(t *testing.T) {
+		VerifyRunner(t, func(steps []multistep.Step) multistep.Runner {
+			return &multistep.SequenceRunner{Runners: []multistep.Runner{
+				&multistep.BasicRunner{Steps: steps},
+			}}
+		})
+	})
+}
+
+func TestVerifyStateBag(t *testing.T) {
+	bags := map[string]func() multistep.StateBag{
+		"BasicStateBag": func() multistep.StateBag { return new(multistep.BasicStateBag) },
+		"SyncStateBag":  func() multistep.StateBag { return new(multistep.SyncStateBag) },
+		"ChildStateBag": func() multistep.StateBag {
+			return &multistep.ChildStateBag{Parent: new(multistep.BasicStateBag)}
+		},
+		"NamespacedStateBag": func() multistep.StateBag {
+			return multistep.NamespacedStateBag(new(multistep.BasicStateBag), "ns")
+		},
+	}
+
+	for name, newBag := range bags {
+		t.Run(name, func(t *testing.T) {
+			VerifyStateBag(t, newBag)
+		})
+	}
+}
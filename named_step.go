@@ -0,0 +1,81 @@
+package multistep
+
+import (
+	"context"
+	"reflect"
+)
+
+// Named is an interface that steps can implement to give themselves a
+// human readable name, for example for logging.
+type Named interface {
+	// Name returns the human readable name of the step.
+	Name() string
+}
+
+// StepUnwrapper is an interface that steps wrapping another can implement
+// so that the BasicRunner looks through them for the optional interfaces
+// of the wrapped step, such as StepWithCleanupContext, Validatable or
+// StepWithUninterruptible. NamedStep, IfStep, RetryStep, TimeoutStep and
+// TracingStep all implement it, so wrapping a step in them doesn't change
+// how it is validated or cleaned up.
+type StepUnwrapper interface {
+	// Unwrap returns the wrapped step.
+	Unwrap() Step
+}
+
+// NamedStep wraps a step to give it a name.
+type NamedStep struct {
+	// StepName is the name of the step.
+	StepName string
+
+	// Step is the step to run.
+	Step Step
+}
+
+func (s *NamedStep) Name() string {
+	return s.StepName
+}
+
+func (s *NamedStep) Run(ctx context.Context, state StateBag) StepAction {
+	return s.Step.Run(ctx, state)
+}
+
+func (s *NamedStep) Cleanup(state StateBag) {
+	s.Step.Cleanup(state)
+}
+
+func (s *NamedStep) Unwrap() Step {
+	return s.Step
+}
+
+// optionalStep returns the step whose optional interfaces apply to step:
+// the step itself, or the innermost step wrapped by StepUnwrappers.
+func optionalStep(step Step) Step {
+	for {
+		u, ok := step.(StepUnwrapper)
+		if !ok {
+			return step
+		}
+
+		inner := u.Unwrap()
+		if inner == nil {
+			return step
+		}
+
+		step = inner
+	}
+}
+
+// StepName returns a human readable name for a step. This is the name
+// returned by Name if the step implements Named, or InnerStepName if it
+// implements StepWrapper. Otherwise it's the name of the step's type.
+func StepName(step Step) string {
+	switch s := step.(type) {
+	case Named:
+		return s.Name()
+	case StepWrapper:
+		return s.InnerStepName()
+	}
+
+	return reflect.Indirect(reflect.ValueOf(step)).Type().Name()
+}
@@ -0,0 +1,68 @@
+package multistep
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestNamedStep_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &NamedStep{}
+	if _, ok := raw.(Step); !ok {
+		t.Fatalf("NamedStep must be a Step")
+	}
+
+	if _, ok := raw.(Named); !ok {
+		t.Fatalf("NamedStep must be Named")
+	}
+}
+
+func TestNamedStep(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &NamedStep{StepName: "create-instance", Step: &TestStepAcc{Data: "a"}}
+
+	r := &BasicRunner{Steps: []Step{step}}
+	r.Run(context.Background(), data)
+
+	expected := []string{"a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+func TestNamedStep_Optional(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TestStepCleanupContext{TestStepAcc: TestStepAcc{Data: "a"}}
+
+	r := &BasicRunner{Steps: []Step{&NamedStep{StepName: "a", Step: step}}}
+	r.Run(context.Background(), data)
+
+	if step.Ctx == nil {
+		t.Fatal("CleanupWithContext should be called through a NamedStep")
+	}
+}
+
+func TestStepName(t *testing.T) {
+	cases := []struct {
+		Step     Step
+		Expected string
+	}{
+		{&NamedStep{StepName: "create-instance"}, "create-instance"},
+		{&TestStepAcc{}, "TestStepAcc"},
+		{TestStepAcc{}, "TestStepAcc"},
+		{&TestStepWrapped{Name: "inner"}, "inner"},
+	}
+
+	for _, tc := range cases {
+		if actual := StepName(tc.Step); actual != tc.Expected {
+			t.Errorf("%#v: expected %q, got %q", tc.Step, tc.Expected, actual)
+		}
+	}
+}
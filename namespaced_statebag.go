@@ -0,0 +1,63 @@
+package multistep
+
+// NamespacedStateBag returns a view of the given bag in which every key is
+// given the prefix, separated by a dot, so that steps written by different
+// people can use the same short keys, like "client", without clashing. A
+// step putting "client" into NamespacedStateBag(state, "db") puts
+// "db.client" into state.
+//
+// The well-known keys of this package, such as StateCancelled, StateHalted
+// and StateError, are not prefixed, so that they still reach the runner.
+// To share any other value between namespaces, use the underlying bag.
+func NamespacedStateBag(state StateBag, prefix string) StateBag {
+	return &namespacedStateBag{state: state, prefix: prefix + "."}
+}
+
+// globalKeys are the keys that NamespacedStateBag leaves unprefixed.
+var globalKeys = map[string]bool{
+	StateCancelled:       true,
+	StateHalted:          true,
+	StateError:           true,
+	StateStepTimings:     true,
+	StateCleanupErrors:   true,
+	StateCleanupTimings:  true,
+	StateCleanupDuration: true,
+	StateNextSteps:       true,
+	StateStepRan:         true,
+	StateRunID:           true,
+	StateFailures:        true,
+	StateMetadata:        true,
+	StateStepResults:     true,
+	StateCleanupOverdue:  true,
+	StateAborted:         true,
+	StateDeadline:        true,
+}
+
+type namespacedStateBag struct {
+	state  StateBag
+	prefix string
+}
+
+func (n *namespacedStateBag) key(k string) string {
+	if globalKeys[k] {
+		return k
+	}
+
+	return n.prefix + k
+}
+
+func (n *namespacedStateBag) Get(k string) interface{} {
+	return n.state.Get(n.key(k))
+}
+
+func (n *namespacedStateBag) GetOk(k string) (interface{}, bool) {
+	return n.state.GetOk(n.key(k))
+}
+
+func (n *namespacedStateBag) Put(k string, v interface{}) {
+	n.state.Put(n.key(k), v)
+}
+
+func (n *namespacedStateBag) Remove(k string) {
+	n.state.Remove(n.key(k))
+}
@@ -0,0 +1,46 @@
+package multistep
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNamespacedStateBag(t *testing.T) {
+	state := new(BasicStateBag)
+	a := NamespacedStateBag(state, "a")
+	b := NamespacedStateBag(state, "b")
+
+	a.Put("client", 1)
+	b.Put("client", 2)
+
+	if a.Get("client") != 1 || b.Get("client") != 2 {
+		t.Fatalf("namespaces should not clash: %#v, %#v", a.Get("client"), b.Get("client"))
+	}
+
+	if state.Get("a.client") != 1 {
+		t.Fatalf("bad: %#v", state.Keys())
+	}
+
+	a.Remove("client")
+	if _, ok := a.GetOk("client"); ok {
+		t.Fatal("should be removed")
+	}
+
+	if _, ok := b.GetOk("client"); !ok {
+		t.Fatal("other namespace should be untouched")
+	}
+}
+
+func TestNamespacedStateBag_GlobalKeys(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		return Halt(NamespacedStateBag(state, "step"), context.Canceled)
+	}}
+
+	r := &BasicRunner{Steps: []Step{step}}
+	r.Run(context.Background(), data)
+
+	if GetError(data) != context.Canceled {
+		t.Fatalf("error should not be namespaced: %#v", data.Keys())
+	}
+}
@@ -0,0 +1,65 @@
+package multistep
+
+// StepObserver is notified as a runner runs each step, for instrumentation
+// such as logging and metrics. Observers are called synchronously from the
+// runner, so they should return quickly.
+type StepObserver interface {
+	// StepStart is called right before the step at the given index is run.
+	StepStart(index int, step Step, state StateBag)
+
+	// StepEnd is called right after the step at the given index has run,
+	// with the action it returned. It is called before the runner acts on
+	// the action, so it is called for halting steps as well. A skipped
+	// step is reported too, with ActionSkip, so StepStart and StepEnd
+	// always come in pairs; only StateStepTimings leaves skipped steps
+	// out.
+	StepEnd(index int, step Step, action StepAction, state StateBag)
+}
+
+// ProgressReporter is told how far through its steps a runner is, for
+// example to drive a progress bar.
+type ProgressReporter interface {
+	// Progress is called after each step has run, including a step that
+	// halted or was cancelled, with the number of steps run so far out of
+	// the total.
+	Progress(completed, total int)
+}
+
+// WeightedProgressReporter is a ProgressReporter that is also told the
+// progress weighted by how much work each step is, as given by Weighted.
+type WeightedProgressReporter interface {
+	ProgressReporter
+
+	// WeightedProgress is called right after Progress, with the total
+	// weight of the steps run so far out of the total weight of all the
+	// steps. Like Progress, a step that halted or was cancelled counts as
+	// run, so a run that stops early reports the weight it reached.
+	WeightedProgress(completed, total float64)
+}
+
+// Weighted is an interface that steps can implement to say how much work
+// they are relative to other steps, for weighted progress reporting. Steps
+// that don't implement it have a weight of 1.
+type Weighted interface {
+	// Weight returns the relative weight of the step.
+	Weight() float64
+}
+
+// stepWeight returns the weight of the step for weighted progress.
+func stepWeight(step Step) float64 {
+	if w, ok := optionalStep(step).(Weighted); ok {
+		return w.Weight()
+	}
+
+	return 1
+}
+
+// totalWeight returns the total weight of the given steps.
+func totalWeight(steps []Step) float64 {
+	var total float64
+	for _, step := range steps {
+		total += stepWeight(step)
+	}
+
+	return total
+}
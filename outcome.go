@@ -0,0 +1,75 @@
+package multistep
+
+import (
+	"context"
+	"fmt"
+)
+
+// An Outcome is how a run of a Runner ended.
+type Outcome uint
+
+const (
+	// OutcomeCompleted means every step ran without halting.
+	OutcomeCompleted Outcome = iota
+
+	// OutcomeHalted means a step halted the run.
+	OutcomeHalted
+
+	// OutcomeCancelled means the run was cancelled.
+	OutcomeCancelled
+
+	// OutcomeAborted means a step aborted the run with ActionAbort, so
+	// none of the cleanups ran.
+	OutcomeAborted
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeCompleted:
+		return "OutcomeCompleted"
+	case OutcomeHalted:
+		return "OutcomeHalted"
+	case OutcomeCancelled:
+		return "OutcomeCancelled"
+	case OutcomeAborted:
+		return "OutcomeAborted"
+	}
+
+	return fmt.Sprintf("Outcome(%d)", uint(o))
+}
+
+// RunAndWait runs the runner with the given state, waits for it to finish
+// and returns the outcome of the run, as read from StateCancelled and
+// StateHalted in the state bag.
+//
+// An abort takes precedence over everything, since it also sets
+// StateHalted, and is read from StateAborted. Otherwise cancellation takes
+// precedence: if both keys are set, for example because a step halted
+// while the run was being cancelled, the outcome is OutcomeCancelled.
+func RunAndWait(ctx context.Context, r Runner, state StateBag) Outcome {
+	r.Run(ctx, state)
+	return outcomeOf(state)
+}
+
+// outcomeOf returns the outcome of a run from its state bag.
+func outcomeOf(state StateBag) Outcome {
+	return outcomeOfKeys(state, StateCancelled, StateHalted)
+}
+
+// outcomeOfKeys is outcomeOf with the given keys in place of
+// StateCancelled and StateHalted.
+func outcomeOfKeys(state StateBag, cancelledKey, haltedKey string) Outcome {
+	if _, ok := state.GetOk(StateAborted); ok {
+		return OutcomeAborted
+	}
+
+	if _, ok := state.GetOk(cancelledKey); ok {
+		return OutcomeCancelled
+	}
+
+	if _, ok := state.GetOk(haltedKey); ok {
+		return OutcomeHalted
+	}
+
+	return OutcomeCompleted
+}
@@ -0,0 +1,80 @@
+package multistep
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOutcome_String(t *testing.T) {
+	cases := map[Outcome]string{
+		OutcomeCompleted: "OutcomeCompleted",
+		OutcomeHalted:    "OutcomeHalted",
+		OutcomeCancelled: "OutcomeCancelled",
+		OutcomeAborted:   "OutcomeAborted",
+		Outcome(42):      "Outcome(42)",
+	}
+
+	for o, expected := range cases {
+		if actual := o.String(); actual != expected {
+			t.Errorf("bad: %s != %s", actual, expected)
+		}
+	}
+}
+
+func TestRunAndWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cancelStep := &FuncStep{RunFunc: func(context.Context, StateBag) StepAction {
+		cancel()
+		return ActionContinue
+	}}
+
+	cases := []struct {
+		Name     string
+		Steps    []Step
+		Expected Outcome
+	}{
+		{"completed", []Step{&TestStepAcc{Data: "a"}}, OutcomeCompleted},
+		{"halted", []Step{&TestStepAcc{Data: "a", Halt: true}}, OutcomeHalted},
+		{"aborted", []Step{&TestStepAcc{Data: "a", Abort: true}}, OutcomeAborted},
+		{"cancelled", []Step{cancelStep, &TestStepAcc{Data: "a"}}, OutcomeCancelled},
+	}
+
+	for _, tc := range cases {
+		r := &BasicRunner{Steps: tc.Steps}
+		actual := RunAndWait(ctx, r, new(BasicStateBag))
+		if actual != tc.Expected {
+			t.Errorf("%s: bad outcome: %s", tc.Name, actual)
+		}
+	}
+}
+
+func TestRunAndWait_CancelledTakesPrecedence(t *testing.T) {
+	step := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		state.Put(StateCancelled, true)
+		return ActionHalt
+	}}
+
+	r := &BasicRunner{Steps: []Step{step}}
+	actual := RunAndWait(context.Background(), r, new(BasicStateBag))
+	if actual != OutcomeCancelled {
+		t.Fatalf("bad outcome: %s", actual)
+	}
+}
+
+func TestRunAndWait_ResetState(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TestStepAcc{Data: "a", Abort: true}
+	r := &BasicRunner{Steps: []Step{step}}
+
+	if outcome := RunAndWait(context.Background(), r, data); outcome != OutcomeAborted {
+		t.Fatalf("bad outcome: %s", outcome)
+	}
+
+	ResetState(data)
+	step.Abort = false
+	if outcome := RunAndWait(context.Background(), r, data); outcome != OutcomeCompleted {
+		t.Fatalf("bad outcome after ResetState: %s", outcome)
+	}
+}
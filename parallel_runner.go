@@ -0,0 +1,201 @@
+package multistep
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Limiter limits the rate at which a ParallelRunner starts its steps. It
+// is satisfied by *rate.Limiter from golang.org/x/time/rate.
+type Limiter interface {
+	// Wait blocks until the next step may start, or returns an error if
+	// it can't, including when the context is cancelled first.
+	Wait(ctx context.Context) error
+}
+
+// ParallelRunner is a Runner that runs all of the given steps concurrently
+// against the same state bag, waiting for all of them to finish.
+//
+// If any step returns ActionHalt, StateHalted is set and the context given
+// to the other steps is cancelled. Once every Run has returned, the steps
+// that were started are cleaned up one at a time, in the reverse order of
+// Steps. Steps that returned ActionSkip are not cleaned up.
+//
+// A step returning ActionAbort halts the run in the same way, but also
+// sets StateAborted, and then no step is cleaned up at all. A step
+// returning ActionReplay is run again, up to DefaultMaxReplays times in a
+// row, and an unknown action halts the run with an error under StateError,
+// as in a BasicRunner.
+type ParallelRunner struct {
+	// Steps is a slice of steps to run. Once set, this should _not_ be
+	// modified.
+	Steps []Step
+
+	// MaxConcurrency is the maximum number of steps to run at the same
+	// time. Steps are started in the order of Steps as earlier ones
+	// finish. Zero means there is no limit. Steps still waiting to start
+	// when the run is halted or cancelled are never started.
+	MaxConcurrency int
+
+	// Limiter, if set, is waited on before each step is started, to limit
+	// the rate at which steps start, for example to stay under the
+	// requests per second of an API. It complements MaxConcurrency. Steps
+	// still waiting on the limiter when the run is halted or cancelled are
+	// never started, nor cleaned up. If Wait fails for any other reason
+	// the run is halted with its error under StateError.
+	Limiter Limiter
+
+	cancel context.CancelFunc
+	doneCh chan struct{}
+	state  runState
+	l      sync.Mutex
+}
+
+func (p *ParallelRunner) Run(parent context.Context, state StateBag) {
+	p.l.Lock()
+	if p.state != stateIdle {
+		panic("already running")
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+
+	doneCh := make(chan struct{})
+	p.cancel = cancel
+	p.doneCh = doneCh
+	p.state = stateRunning
+	p.l.Unlock()
+
+	defer func() {
+		p.l.Lock()
+		cancel()
+		p.cancel = nil
+		p.doneCh = nil
+		p.state = stateIdle
+		close(doneCh)
+		p.l.Unlock()
+	}()
+
+	var haltOnce, abortOnce sync.Once
+	halted := false
+	aborted := false
+	actions := make([]StepAction, len(p.Steps))
+	started := make([]bool, len(p.Steps))
+
+	var sem chan struct{}
+	if p.MaxConcurrency > 0 {
+		sem = make(chan struct{}, p.MaxConcurrency)
+	}
+
+	var wg sync.WaitGroup
+	for i, step := range p.Steps {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		if p.Limiter != nil {
+			if err := p.Limiter.Wait(ctx); err != nil {
+				if ctx.Err() == nil {
+					haltOnce.Do(func() {
+						halted = true
+						state.Put(StateError, err)
+						state.Put(StateHalted, true)
+						cancel()
+					})
+				}
+				break
+			}
+		}
+
+		started[i] = true
+		wg.Add(1)
+		go func(i int, step Step) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+
+			actions[i] = p.runStep(ctx, i, step, state)
+			if actions[i] == ActionAbort {
+				abortOnce.Do(func() {
+					aborted = true
+					state.Put(StateAborted, true)
+				})
+			}
+
+			if actions[i] == ActionHalt || actions[i] == ActionAbort {
+				haltOnce.Do(func() {
+					halted = true
+					state.Put(StateHalted, true)
+					cancel()
+				})
+			}
+		}(i, step)
+	}
+	wg.Wait()
+
+	if !halted && ctx.Err() != nil {
+		state.Put(StateCancelled, true)
+	}
+
+	if aborted {
+		return
+	}
+
+	for i := len(p.Steps) - 1; i >= 0; i-- {
+		if started[i] && actions[i] != ActionSkip {
+			p.Steps[i].Cleanup(state)
+		}
+	}
+}
+
+// runStep runs the step, running it again while it returns ActionReplay,
+// and returns the action to act on. Too many replays, or an unknown
+// action, halt with an error.
+func (p *ParallelRunner) runStep(ctx context.Context, index int, step Step, state StateBag) StepAction {
+	action := step.Run(ctx, state)
+	for replays := 0; action == ActionReplay; replays++ {
+		if ctx.Err() != nil {
+			return ActionHalt
+		}
+
+		if replays >= DefaultMaxReplays {
+			return Halt(state, fmt.Errorf("step replayed more than %d times", replays))
+		}
+
+		action = step.Run(ctx, state)
+	}
+
+	if !isKnownAction(action) {
+		return Halt(state, fmt.Errorf("multistep: step %d (%s) returned unknown action %v", index, StepName(step), action))
+	}
+
+	return action
+}
+
+func (p *ParallelRunner) Cancel() {
+	p.l.Lock()
+	switch p.state {
+	case stateIdle:
+		// Not running, so Cancel is... done.
+		p.l.Unlock()
+		return
+	case stateRunning:
+		// Running, so mark that we cancelled and set the state
+		p.cancel()
+		p.state = stateCancelling
+		fallthrough
+	case stateCancelling:
+		// Already cancelling, so just wait until we're done
+		ch := p.doneCh
+		p.l.Unlock()
+		<-ch
+	}
+}
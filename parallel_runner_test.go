@@ -0,0 +1,340 @@
+package multistep
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallelRunner_ImplRunner(t *testing.T) {
+	var raw interface{}
+	raw = &ParallelRunner{}
+	if _, ok := raw.(Runner); !ok {
+		t.Fatalf("ParallelRunner must be a Runner")
+	}
+}
+
+func TestParallelRunner_Run(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepB := &TestStepAcc{Data: "b"}
+	stepC := &TestStepAcc{Data: "c", Skip: true}
+
+	r := &ParallelRunner{Steps: []Step{stepA, stepB, stepC}}
+	r.Run(context.Background(), data)
+
+	// Test cleanup data, which is in reverse order and skips c
+	expected := []string{"b", "a"}
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateCancelled); ok {
+		t.Errorf("cancelled should not be in state bag")
+	}
+
+	if _, ok := data.GetOk(StateHalted); ok {
+		t.Errorf("halted should not be in state bag")
+	}
+}
+
+func TestParallelRunner_Run_Halt(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a", Halt: true}
+	stepWait := &TestStepWaitCancel{}
+
+	r := &ParallelRunner{Steps: []Step{stepA, stepWait}}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Errorf("halted should be in state bag")
+	}
+
+	if _, ok := data.GetOk(StateCancelled); ok {
+		t.Errorf("cancelled should not be in state bag")
+	}
+
+	// Both steps started, so both are cleaned up
+	expected := []string{"a"}
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if !stepWait.CleanedUp {
+		t.Errorf("waiting step should be cleaned up")
+	}
+}
+
+func TestParallelRunner_Run_Abort(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a", Abort: true}
+	stepWait := &TestStepWaitCancel{}
+
+	r := &ParallelRunner{Steps: []Step{stepA, stepWait}}
+	if outcome := RunAndWait(context.Background(), r, data); outcome != OutcomeAborted {
+		t.Errorf("bad outcome: %s", outcome)
+	}
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Errorf("halted should be in state bag")
+	}
+
+	// The waiting step was cancelled, but nothing is cleaned up
+	if _, ok := data.GetOk("cleanup"); ok || stepWait.CleanedUp {
+		t.Errorf("no step should be cleaned up")
+	}
+}
+
+func TestParallelRunner_Run_Actions(t *testing.T) {
+	runs := 0
+	replay := &FuncStep{RunFunc: func(context.Context, StateBag) StepAction {
+		runs++
+		if runs < 2 {
+			return ActionReplay
+		}
+		return ActionContinue
+	}}
+
+	data := new(BasicStateBag)
+	r := &ParallelRunner{Steps: []Step{replay}}
+	r.Run(context.Background(), data)
+	if runs != 2 {
+		t.Errorf("bad runs: %d", runs)
+	}
+	if _, ok := data.GetOk(StateHalted); ok {
+		t.Errorf("halted should not be in state bag")
+	}
+
+	unknown := &FuncStep{RunFunc: func(context.Context, StateBag) StepAction { return StepAction(42) }}
+	data = new(BasicStateBag)
+	r = &ParallelRunner{Steps: []Step{unknown}}
+	r.Run(context.Background(), data)
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Errorf("an unknown action should halt")
+	}
+	if GetError(data) == nil {
+		t.Errorf("should have an error")
+	}
+}
+
+func TestParallelRunner_Run_HaltCancelsSiblings(t *testing.T) {
+	data := new(BasicStateBag)
+	started := make(chan struct{})
+	fast := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		// Only halt once the slow step is running
+		<-started
+		return ActionHalt
+	}}
+
+	var seen error
+	slow := &FuncStep{RunFunc: func(ctx context.Context, _ StateBag) StepAction {
+		close(started)
+
+		select {
+		case <-ctx.Done():
+			seen = ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+		return ActionContinue
+	}}
+
+	r := &ParallelRunner{Steps: []Step{fast, slow}}
+
+	start := time.Now()
+	r.Run(context.Background(), data)
+
+	if seen != context.Canceled {
+		t.Fatalf("slow step should see its context cancelled: %v", seen)
+	}
+
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("slow step should stop promptly, took %s", d)
+	}
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Fatal("halted should be in state bag")
+	}
+}
+
+func TestParallelRunner_Cancel(t *testing.T) {
+	ch := make(chan chan bool)
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a"}
+	stepInt := &TestStepSync{ch}
+	stepWait := &TestStepWaitCancel{}
+
+	r := &ParallelRunner{Steps: []Step{stepA, stepInt, stepWait}}
+
+	// cancelling an idle Runner is a no-op
+	r.Cancel()
+
+	go r.Run(context.Background(), data)
+
+	// Wait until we reach the sync point
+	responseCh := <-ch
+
+	cancelCh := make(chan bool)
+	go func() {
+		r.Cancel()
+		cancelCh <- true
+	}()
+
+	// Let the sync step finish; the waiting step only returns once the
+	// context is cancelled.
+	responseCh <- true
+	<-cancelCh
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Errorf("cancelled should be in state bag")
+	}
+
+	if !stepWait.CleanedUp {
+		t.Errorf("waiting step should be cleaned up")
+	}
+}
+
+func TestParallelRunner_Run_MaxConcurrency(t *testing.T) {
+	var l sync.Mutex
+	running, maxRunning := 0, 0
+	step := &FuncStep{RunFunc: func(context.Context, StateBag) StepAction {
+		l.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		l.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		l.Lock()
+		running--
+		l.Unlock()
+		return ActionContinue
+	}}
+
+	r := &ParallelRunner{
+		Steps:          []Step{step, step, step, step, step, step},
+		MaxConcurrency: 2,
+	}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	if maxRunning != 2 {
+		t.Errorf("bad max concurrency: %d", maxRunning)
+	}
+}
+
+func TestParallelRunner_Run_MaxConcurrency_Halt(t *testing.T) {
+	data := new(BasicStateBag)
+	stepA := &TestStepAcc{Data: "a", Halt: true}
+	stepB := &TestStepAcc{Data: "b"}
+
+	r := &ParallelRunner{
+		Steps:          []Step{stepA, stepB},
+		MaxConcurrency: 1,
+	}
+	r.Run(context.Background(), data)
+
+	// The queued step never started, so it isn't cleaned up either
+	expected := []string{"a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+// A Limiter that lets a step start for each token sent on Tokens, or
+// fails with Err if it is set
+type TestLimiter struct {
+	Tokens chan struct{}
+	Err    error
+}
+
+func (l *TestLimiter) Wait(ctx context.Context) error {
+	if l.Err != nil {
+		return l.Err
+	}
+
+	select {
+	case <-l.Tokens:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestParallelRunner_Run_Limiter(t *testing.T) {
+	data := new(BasicStateBag)
+	limiter := &TestLimiter{Tokens: make(chan struct{}, 2)}
+	limiter.Tokens <- struct{}{}
+	limiter.Tokens <- struct{}{}
+
+	r := &ParallelRunner{
+		Steps: []Step{
+			&TestStepAcc{Data: "a"},
+			&TestStepAcc{Data: "b"},
+			&TestStepAcc{Data: "c"},
+		},
+		Limiter: limiter,
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	// The third step waits on the limiter until the run is cancelled
+	waitForKey(data, "data")
+	for len(data.Get("data").([]string)) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	r.Cancel()
+	<-doneCh
+
+	// The step cancelled while waiting never ran, and isn't cleaned up
+	results := data.Get("data").([]string)
+	sort.Strings(results)
+	if !reflect.DeepEqual(results, []string{"a", "b"}) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"b", "a"}) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Errorf("cancelled should be in state bag")
+	}
+}
+
+func TestParallelRunner_Run_LimiterError(t *testing.T) {
+	errLimit := errors.New("would exceed burst")
+	data := new(BasicStateBag)
+	r := &ParallelRunner{
+		Steps:   []Step{&TestStepAcc{Data: "a"}},
+		Limiter: &TestLimiter{Err: errLimit},
+	}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk("data"); ok {
+		t.Error("step should not run")
+	}
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Error("halted should be in state bag")
+	}
+	if err := GetError(data); err != errLimit {
+		t.Errorf("bad error: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package multistep
+
+import (
+	"context"
+	"sync"
+)
+
+// Phase is a named group of steps for a PhaseRunner, such as "setup",
+// "build" or "publish".
+type Phase struct {
+	// Name is the name of the phase, given to the callbacks.
+	Name string
+
+	// Steps is the steps of the phase, run in order.
+	Steps []Step
+}
+
+// PhaseRunner is a Runner that runs its steps in named phases, calling
+// OnPhaseStart and OnPhaseEnd around each phase, for example for logging
+// and metrics. The steps of all the phases are run one after another
+// exactly like a BasicRunner, with the same cancellation and cleanup
+// behavior: once the run stops, every step that ran is cleaned up in
+// reverse order, whatever phase it was in.
+//
+// If a step halts, or the run is cancelled, the phase it was in fails and
+// the later phases are never started. OnPhaseEnd is called for the failed
+// phase once the cleanups are done.
+type PhaseRunner struct {
+	// Phases is the phases to run, in order. Once set, this should _not_
+	// be modified.
+	Phases []Phase
+
+	// OnPhaseStart, if set, is called right before the first step of each
+	// phase is run.
+	OnPhaseStart func(name string)
+
+	// OnPhaseEnd, if set, is called when each phase that was started is
+	// over, with ok false if the phase failed.
+	OnPhaseEnd func(name string, ok bool)
+
+	l      sync.Mutex
+	runner *BasicRunner
+	cancel context.CancelCauseFunc
+}
+
+func (r *PhaseRunner) Run(ctx context.Context, state StateBag) {
+	// The phase that has started but not yet ended, if any. It is only
+	// used by the steps that mark the phases, which run one at a time.
+	var current *Phase
+
+	var steps []Step
+	for i := range r.Phases {
+		phase := &r.Phases[i]
+		steps = append(steps, &phaseStep{func() {
+			current = phase
+			if r.OnPhaseStart != nil {
+				r.OnPhaseStart(phase.Name)
+			}
+		}})
+		steps = append(steps, phase.Steps...)
+		steps = append(steps, &phaseStep{func() {
+			current = nil
+			if r.OnPhaseEnd != nil {
+				r.OnPhaseEnd(phase.Name, true)
+			}
+		}})
+	}
+
+	r.l.Lock()
+	if r.runner != nil {
+		r.l.Unlock()
+		panic("already running")
+	}
+	runCtx, cancel := context.WithCancelCause(ctx)
+	r.runner = &BasicRunner{Steps: steps}
+	r.cancel = cancel
+	r.l.Unlock()
+
+	defer func() {
+		r.l.Lock()
+		r.runner = nil
+		r.cancel = nil
+		r.l.Unlock()
+		cancel(context.Canceled)
+	}()
+
+	r.runner.Run(runCtx, state)
+
+	if current != nil && r.OnPhaseEnd != nil {
+		r.OnPhaseEnd(current.Name, false)
+	}
+}
+
+func (r *PhaseRunner) Cancel() {
+	r.l.Lock()
+	runner, cancel := r.runner, r.cancel
+	r.l.Unlock()
+
+	if runner != nil {
+		// Cancelling the context also cancels a run that hasn't started,
+		// and unlike Cancel never cancels the next run of the runner.
+		cancel(ErrUserCancel)
+		runner.cancelRunning()
+	}
+}
+
+// phaseStep marks the start or end of a phase. It returns ActionSkip, so
+// it is never cleaned up.
+type phaseStep struct {
+	mark func()
+}
+
+func (s *phaseStep) Run(context.Context, StateBag) StepAction {
+	s.mark()
+	return ActionSkip
+}
+
+func (s *phaseStep) Cleanup(StateBag) {}
@@ -0,0 +1,106 @@
+package multistep
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPhaseRunner_ImplRunner(t *testing.T) {
+	var raw interface{}
+	raw = &PhaseRunner{}
+	if _, ok := raw.(Runner); !ok {
+		t.Fatalf("PhaseRunner must be a Runner")
+	}
+}
+
+func TestPhaseRunner_Run(t *testing.T) {
+	for _, halt := range []bool{false, true} {
+		data := new(BasicStateBag)
+
+		var events []string
+		r := &PhaseRunner{
+			Phases: []Phase{
+				{Name: "setup", Steps: []Step{&TestStepAcc{Data: "a"}}},
+				{Name: "build", Steps: []Step{&TestStepAcc{Data: "b"}, &TestStepAcc{Data: "c", Halt: halt}}},
+				{Name: "empty"},
+				{Name: "publish", Steps: []Step{&TestStepAcc{Data: "d"}}},
+			},
+			OnPhaseStart: func(name string) {
+				events = append(events, "start "+name)
+			},
+			OnPhaseEnd: func(name string, ok bool) {
+				events = append(events, fmt.Sprintf("end %s %t", name, ok))
+			},
+		}
+		r.Run(context.Background(), data)
+
+		expected := []string{
+			"start setup", "end setup true",
+			"start build", "end build true",
+			"start empty", "end empty true",
+			"start publish", "end publish true",
+		}
+		results := []string{"a", "b", "c", "d"}
+		if halt {
+			expected = []string{
+				"start setup", "end setup true",
+				"start build", "end build false",
+			}
+			results = []string{"a", "b", "c"}
+		}
+
+		if !reflect.DeepEqual(events, expected) {
+			t.Fatalf("halt=%t: unexpected events: %#v", halt, events)
+		}
+
+		if actual := data.Get("data").([]string); !reflect.DeepEqual(actual, results) {
+			t.Fatalf("halt=%t: unexpected results: %#v", halt, actual)
+		}
+
+		// Cleanups run across the phases in reverse
+		for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+			results[i], results[j] = results[j], results[i]
+		}
+		if actual := data.Get("cleanup").([]string); !reflect.DeepEqual(actual, results) {
+			t.Fatalf("halt=%t: unexpected cleanups: %#v", halt, actual)
+		}
+	}
+}
+
+func TestPhaseRunner_Cancel(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+
+	var events []string
+	r := &PhaseRunner{
+		Phases: []Phase{
+			{Name: "setup", Steps: []Step{step}},
+			{Name: "build", Steps: []Step{&TestStepAcc{Data: "b"}}},
+		},
+		OnPhaseEnd: func(name string, ok bool) {
+			events = append(events, fmt.Sprintf("end %s %t", name, ok))
+		},
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	<-step.Started
+	r.Cancel()
+	<-doneCh
+
+	if !reflect.DeepEqual(events, []string{"end setup false"}) {
+		t.Fatalf("unexpected events: %#v", events)
+	}
+	if !step.CleanedUp {
+		t.Fatal("step should be cleaned up")
+	}
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Fatal("cancelled should be in state bag")
+	}
+}
@@ -0,0 +1,77 @@
+package multistep
+
+import (
+	"context"
+	"time"
+)
+
+// RetryStep wraps a step, running it again each time it returns ActionHalt
+// until it succeeds or MaxAttempts runs have been made.
+//
+// The Cleanup of every failed attempt except the last is called right away,
+// before the next attempt starts. The last attempt is cleaned up through
+// RetryStep's own Cleanup, as with any other step. StateError is removed
+// before each new attempt, so an error put by a failed attempt doesn't
+// outlive it.
+type RetryStep struct {
+	// Step is the step to run.
+	Step Step
+
+	// MaxAttempts is the maximum number of times to run the step. Values
+	// less than one are treated as one.
+	MaxAttempts int
+
+	// Backoff returns how long to wait after the given attempt (starting
+	// at 1) fails before trying again. If it is nil, there is no wait.
+	Backoff func(attempt int) time.Duration
+
+	// Clock, if set, is used to wait out the backoff instead of the real
+	// time, for tests.
+	Clock Clock
+}
+
+func (s *RetryStep) Run(ctx context.Context, state StateBag) StepAction {
+	for attempt := 1; ; attempt++ {
+		action := s.Step.Run(ctx, state)
+		if action != ActionHalt || attempt >= s.MaxAttempts {
+			return action
+		}
+
+		// If we're cancelled we give up, leaving this attempt to be
+		// cleaned up by the runner like the last one.
+		if !s.wait(ctx, attempt) {
+			return ActionHalt
+		}
+
+		s.Step.Cleanup(state)
+		state.Remove(StateError)
+	}
+}
+
+func (s *RetryStep) Cleanup(state StateBag) {
+	s.Step.Cleanup(state)
+}
+
+func (s *RetryStep) Unwrap() Step {
+	return s.Step
+}
+
+// wait sleeps for the backoff of the given attempt, returning false if the
+// context was cancelled first.
+func (s *RetryStep) wait(ctx context.Context, attempt int) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var d time.Duration
+	if s.Backoff != nil {
+		d = s.Backoff(attempt)
+	}
+
+	select {
+	case <-clockOrReal(s.Clock).After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,190 @@
+package multistep
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetryStep_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &RetryStep{}
+	if _, ok := raw.(Step); !ok {
+		t.Fatalf("RetryStep must be a Step")
+	}
+}
+
+func TestRetryStep(t *testing.T) {
+	var calls []string
+	attempts := 0
+	inner := &FuncStep{
+		RunFunc: func(context.Context, StateBag) StepAction {
+			attempts++
+			calls = append(calls, "run")
+			if attempts < 3 {
+				return ActionHalt
+			}
+			return ActionContinue
+		},
+		CleanupFunc: func(StateBag) {
+			calls = append(calls, "cleanup")
+		},
+	}
+
+	var backoffs []int
+	step := &RetryStep{
+		Step:        inner,
+		MaxAttempts: 5,
+		Backoff: func(attempt int) time.Duration {
+			backoffs = append(backoffs, attempt)
+			return time.Millisecond
+		},
+	}
+
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{step}}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk(StateHalted); ok {
+		t.Errorf("halted should not be in state bag")
+	}
+
+	expected := []string{"run", "cleanup", "run", "cleanup", "run", "cleanup"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("unexpected calls: %#v", calls)
+	}
+
+	if !reflect.DeepEqual(backoffs, []int{1, 2}) {
+		t.Errorf("unexpected backoffs: %#v", backoffs)
+	}
+}
+
+func TestRetryStep_Exhausted(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &RetryStep{
+		Step:        &TestStepAcc{Data: "a", Halt: true},
+		MaxAttempts: 3,
+	}
+
+	r := &BasicRunner{Steps: []Step{step}}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Errorf("halted should be in state bag")
+	}
+
+	expected := []string{"a", "a", "a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+func TestRetryStep_ClearsError(t *testing.T) {
+	attempts := 0
+	step := &RetryStep{
+		Step: &FuncStep{
+			RunFunc: func(_ context.Context, state StateBag) StepAction {
+				attempts++
+				if attempts < 2 {
+					state.Put(StateError, errors.New("failed"))
+					return ActionHalt
+				}
+				return ActionContinue
+			},
+		},
+		MaxAttempts: 3,
+	}
+
+	data := new(BasicStateBag)
+	r := &BasicRunner{Steps: []Step{step}}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk(StateError); ok {
+		t.Fatalf("error should not be in state bag: %#v", data.Get(StateError))
+	}
+}
+
+func TestRetryStep_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	data := new(BasicStateBag)
+	step := &RetryStep{
+		Step:        &TestStepAcc{Data: "a", Halt: true},
+		MaxAttempts: 3,
+		Backoff: func(int) time.Duration {
+			cancel()
+			return time.Hour
+		},
+	}
+
+	done := make(chan StepAction, 1)
+	go func() { done <- step.Run(ctx, data) }()
+
+	select {
+	case action := <-done:
+		if action != ActionHalt {
+			t.Errorf("bad action: %#v", action)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("retry did not stop on cancellation")
+	}
+
+	expected := []string{"a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	// The failed attempt is left for the runner to clean up
+	if _, ok := data.GetOk("cleanup"); ok {
+		t.Errorf("cleanup should not have run")
+	}
+}
+
+func TestRetryStep_Clock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	attempts := 0
+	inner := &FuncStep{RunFunc: func(context.Context, StateBag) StepAction {
+		attempts++
+		if attempts < 2 {
+			return ActionHalt
+		}
+		return ActionContinue
+	}}
+
+	step := &RetryStep{
+		Step:        inner,
+		MaxAttempts: 2,
+		Backoff:     func(int) time.Duration { return time.Hour },
+		Clock:       clock,
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r := &BasicRunner{Steps: []Step{step}}
+		r.Run(context.Background(), new(BasicStateBag))
+		close(doneCh)
+	}()
+
+	clock.WaitForWaiters(1)
+	clock.Advance(time.Hour)
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("backoff should end once the fake clock advances")
+	}
+
+	if attempts != 2 {
+		t.Fatalf("bad attempts: %d", attempts)
+	}
+}
@@ -0,0 +1,77 @@
+package multistep
+
+import (
+	"context"
+	"fmt"
+)
+
+// RunnerStep is a Step that runs a whole Runner, so that a sequence of
+// steps can be nested inside another.
+//
+// The inner runner is given the same context and state bag as the step, so
+// cancelling the outer run cancels the inner one too. If the inner run is
+// halted or cancelled, RunnerStep returns ActionHalt. If it was aborted,
+// RunnerStep returns ActionAbort, so the outer run is aborted too.
+//
+// If the inner run halted with an error under StateError, the error is
+// wrapped as "in nested runner: <err>", so that the RunE of the outer
+// runner returns it, still matching the original with errors.Is. A
+// cancelled inner run leaves StateCancelled set, so the outer RunE
+// returns the cause of the cancel as usual.
+//
+// StateHalted, StateCancelled and StateAborted left in the bag by earlier
+// steps, such as another RunnerStep under ContinueOnHalt, are set aside
+// while the inner runner runs so they aren't taken for its own, then put
+// back if it didn't set them itself.
+type RunnerStep struct {
+	// Runner is the runner to run.
+	Runner Runner
+}
+
+// runnerFlags are the keys a runner sets to say how its run ended.
+var runnerFlags = []string{StateHalted, StateCancelled, StateAborted}
+
+func (s *RunnerStep) Run(ctx context.Context, state StateBag) StepAction {
+	saved := make(map[string]interface{})
+	for _, k := range runnerFlags {
+		if v, ok := state.GetOk(k); ok {
+			saved[k] = v
+			state.Remove(k)
+		}
+	}
+
+	s.Runner.Run(ctx, state)
+	action := s.action(state)
+
+	for k, v := range saved {
+		if _, ok := state.GetOk(k); !ok {
+			state.Put(k, v)
+		}
+	}
+
+	return action
+}
+
+// action works out what to return from the flags the inner run set.
+func (s *RunnerStep) action(state StateBag) StepAction {
+	if _, ok := state.GetOk(StateAborted); ok {
+		return ActionAbort
+	}
+
+	if _, ok := state.GetOk(StateCancelled); ok {
+		return ActionHalt
+	}
+
+	if _, ok := state.GetOk(StateHalted); ok {
+		if err := GetError(state); err != nil {
+			state.Put(StateError, fmt.Errorf("in nested runner: %w", err))
+		}
+		return ActionHalt
+	}
+
+	return ActionContinue
+}
+
+// Cleanup does nothing, since the inner runner cleans up its own steps
+// before its Run returns.
+func (s *RunnerStep) Cleanup(StateBag) {}
@@ -0,0 +1,181 @@
+package multistep
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunnerStep_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &RunnerStep{}
+	if _, ok := raw.(Step); !ok {
+		t.Fatalf("RunnerStep must be a Step")
+	}
+}
+
+func TestRunnerStep(t *testing.T) {
+	data := new(BasicStateBag)
+	inner := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "b"}, &TestStepAcc{Data: "c"}}}
+
+	r := &BasicRunner{Steps: []Step{
+		&TestStepAcc{Data: "a"},
+		&RunnerStep{Runner: inner},
+		&TestStepAcc{Data: "d"},
+	}}
+	r.Run(context.Background(), data)
+
+	expected := []string{"a", "b", "c", "d"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	expected = []string{"c", "b", "d", "a"}
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+func TestRunnerStep_Halt(t *testing.T) {
+	data := new(BasicStateBag)
+	inner := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "b", Halt: true}}}
+
+	r := &BasicRunner{Steps: []Step{
+		&TestStepAcc{Data: "a"},
+		&RunnerStep{Runner: inner},
+		&TestStepAcc{Data: "c"},
+	}}
+	r.Run(context.Background(), data)
+
+	expected := []string{"a", "b"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Errorf("halted should be in state bag")
+	}
+}
+
+func TestRunnerStep_ContinueOnHalt(t *testing.T) {
+	data := new(BasicStateBag)
+	halting := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a", Halt: true}}}
+	completing := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "b"}}}
+
+	r := &BasicRunner{
+		Steps: []Step{
+			&RunnerStep{Runner: halting},
+			&RunnerStep{Runner: completing},
+		},
+		ContinueOnHalt: true,
+	}
+	r.Run(context.Background(), data)
+
+	failures := data.Get(StateFailures).([]StepFailure)
+	if len(failures) != 1 || failures[0].Index != 0 {
+		t.Fatalf("unexpected failures: %#v", failures)
+	}
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Errorf("halted should be in state bag")
+	}
+}
+
+func TestRunnerStep_Abort(t *testing.T) {
+	data := new(BasicStateBag)
+	inner := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "b", Abort: true}}}
+
+	r := &BasicRunner{Steps: []Step{
+		&TestStepAcc{Data: "a"},
+		&RunnerStep{Runner: inner},
+		&TestStepAcc{Data: "c"},
+	}}
+	r.Run(context.Background(), data)
+
+	expected := []string{"a", "b"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk("cleanup"); ok {
+		t.Errorf("outer steps should not be cleaned up: %#v", data.Get("cleanup"))
+	}
+}
+
+func TestRunnerStep_Cancel(t *testing.T) {
+	data := new(BasicStateBag)
+	stepWait := &TestStepWaitCancel{Started: make(chan struct{})}
+	inner := &BasicRunner{Steps: []Step{stepWait, &TestStepAcc{Data: "b"}}}
+
+	r := &BasicRunner{Steps: []Step{
+		&TestStepAcc{Data: "a"},
+		&RunnerStep{Runner: inner},
+		&TestStepAcc{Data: "c"},
+	}}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	// Wait until the inner runner is running the waiting step
+	<-stepWait.Started
+
+	// Cancelling the outer runner cancels the inner one through the
+	// context, letting the waiting step return
+	r.Cancel()
+	<-doneCh
+
+	expected := []string{"a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if !stepWait.CleanedUp {
+		t.Errorf("inner step should be cleaned up")
+	}
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Errorf("cancelled should be in state bag")
+	}
+}
+
+func TestRunnerStep_HaltError(t *testing.T) {
+	errBoom := errors.New("boom")
+	innermost := &BasicRunner{Steps: []Step{&FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		return Halt(state, errBoom)
+	}}}}
+	inner := &BasicRunner{Steps: []Step{&RunnerStep{Runner: innermost}}}
+	r := &BasicRunner{Steps: []Step{&RunnerStep{Runner: inner}}}
+
+	err := r.RunE(context.Background(), new(BasicStateBag))
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("bad error: %v", err)
+	}
+	if err.Error() != "in nested runner: in nested runner: boom" {
+		t.Fatalf("bad message: %q", err.Error())
+	}
+}
+
+func TestRunnerStep_CancelError(t *testing.T) {
+	stepWait := &TestStepWaitCancel{Started: make(chan struct{})}
+	inner := &BasicRunner{Steps: []Step{stepWait}}
+	r := &BasicRunner{Steps: []Step{&RunnerStep{Runner: inner}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stepWait.Started
+		cancel()
+	}()
+
+	if err := r.RunE(ctx, new(BasicStateBag)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("bad error: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package multistep
+
+import (
+	"context"
+	"sync"
+)
+
+// SequenceRunner is a Runner that runs a slice of other runners one after
+// another, all sharing the same state bag.
+//
+// After each runner finishes, the sequence stops early if StateHalted or
+// StateCancelled is in the state bag.
+type SequenceRunner struct {
+	// Runners is a slice of runners to run in order. Once set, this
+	// should _not_ be modified.
+	Runners []Runner
+
+	cancel  context.CancelFunc
+	current Runner
+	doneCh  chan struct{}
+	state   runState
+	l       sync.Mutex
+}
+
+func (s *SequenceRunner) Run(parent context.Context, state StateBag) {
+	s.l.Lock()
+	if s.state != stateIdle {
+		panic("already running")
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+
+	doneCh := make(chan struct{})
+	s.cancel = cancel
+	s.doneCh = doneCh
+	s.state = stateRunning
+	s.l.Unlock()
+
+	defer func() {
+		s.l.Lock()
+		cancel()
+		s.cancel = nil
+		s.current = nil
+		s.doneCh = nil
+		s.state = stateIdle
+		close(doneCh)
+		s.l.Unlock()
+	}()
+
+	for _, r := range s.Runners {
+		s.l.Lock()
+		if s.state == stateCancelling {
+			s.l.Unlock()
+			state.Put(StateCancelled, true)
+			return
+		}
+		s.current = r
+		s.l.Unlock()
+
+		r.Run(ctx, state)
+
+		s.l.Lock()
+		s.current = nil
+		s.l.Unlock()
+
+		if _, ok := state.GetOk(StateCancelled); ok {
+			return
+		}
+
+		if _, ok := state.GetOk(StateHalted); ok {
+			return
+		}
+	}
+}
+
+// Cancel cancels the runner that is currently running, prevents any later
+// runners from starting, and waits for the sequence to finish.
+func (s *SequenceRunner) Cancel() {
+	s.l.Lock()
+	switch s.state {
+	case stateIdle:
+		// Not running, so Cancel is... done.
+		s.l.Unlock()
+		return
+	case stateRunning:
+		// Running, so mark that we cancelled and set the state
+		s.cancel()
+		s.state = stateCancelling
+		fallthrough
+	case stateCancelling:
+		// Cancel the active runner, then wait until we're done
+		ch := s.doneCh
+		current := s.current
+		s.l.Unlock()
+
+		if current != nil {
+			cancelRun(current)
+		}
+		<-ch
+	}
+}
@@ -0,0 +1,95 @@
+package multistep
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSequenceRunner_ImplRunner(t *testing.T) {
+	var raw interface{}
+	raw = &SequenceRunner{}
+	if _, ok := raw.(Runner); !ok {
+		t.Fatalf("SequenceRunner must be a Runner")
+	}
+}
+
+func TestSequenceRunner_Run(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &SequenceRunner{Runners: []Runner{
+		&BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}, &TestStepAcc{Data: "b"}}},
+		&BasicRunner{Steps: []Step{&TestStepAcc{Data: "c"}}},
+	}}
+	r.Run(context.Background(), data)
+
+	// Test run data
+	expected := []string{"a", "b", "c"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	// Each runner cleans up its own steps when it finishes
+	expected = []string{"b", "a", "c"}
+	results = data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+}
+
+func TestSequenceRunner_Run_Halt(t *testing.T) {
+	data := new(BasicStateBag)
+	r := &SequenceRunner{Runners: []Runner{
+		&BasicRunner{Steps: []Step{&TestStepAcc{Data: "a", Halt: true}}},
+		&BasicRunner{Steps: []Step{&TestStepAcc{Data: "b"}}},
+	}}
+	r.Run(context.Background(), data)
+
+	expected := []string{"a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Errorf("halted should be in state bag")
+	}
+}
+
+func TestSequenceRunner_Cancel(t *testing.T) {
+	ch := make(chan chan bool)
+	data := new(BasicStateBag)
+	r := &SequenceRunner{Runners: []Runner{
+		&BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}, &TestStepSync{ch}, &TestStepAcc{Data: "b"}}},
+		&BasicRunner{Steps: []Step{&TestStepAcc{Data: "c"}}},
+	}}
+
+	// cancelling an idle Runner is a no-op
+	r.Cancel()
+
+	go r.Run(context.Background(), data)
+
+	// Wait until we reach the sync point
+	responseCh := <-ch
+
+	cancelCh := make(chan bool)
+	go func() {
+		r.Cancel()
+		cancelCh <- true
+	}()
+
+	waitForKey(data, StateCancelled)
+	responseCh <- true
+	<-cancelCh
+
+	// Neither the rest of the first runner nor the second one ran
+	expected := []string{"a"}
+	results := data.Get("data").([]string)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("unexpected result: %#v", results)
+	}
+
+	if _, ok := data.GetOk(StateCancelled); !ok {
+		t.Errorf("cancelled should be in state bag")
+	}
+}
@@ -0,0 +1,51 @@
+package multistep
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// RunWithSignals runs the runner with the given state like RunAndWait,
+// cancelling it when one of the given signals is received, by default
+// SIGINT or SIGTERM. It waits for the cancelled run to finish cleaning up
+// before returning, so that Ctrl-C cleanly aborts a run.
+//
+// A second signal returns OutcomeCancelled straight away, without waiting
+// any longer for the run, which carries on cleaning up in the background.
+func RunWithSignals(ctx context.Context, r Runner, state StateBag, signals ...os.Signal) Outcome {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, signals...)
+	defer signal.Stop(sigCh)
+
+	// Cancelling the context, rather than calling Cancel, can't cancel a
+	// later run of r if this one has just finished.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(context.Canceled)
+
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		r.Run(ctx, state)
+	}()
+
+	select {
+	case <-doneCh:
+		return outcomeOf(state)
+	case <-sigCh:
+	}
+
+	cancel(ErrUserCancel)
+
+	select {
+	case <-doneCh:
+		return outcomeOf(state)
+	case <-sigCh:
+		return OutcomeCancelled
+	}
+}
@@ -0,0 +1,90 @@
+//go:build unix
+
+package multistep
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunWithSignals(t *testing.T) {
+	step := &TestStepWaitCancel{Started: make(chan struct{})}
+	r := &BasicRunner{Steps: []Step{step, &TestStepAcc{Data: "b"}}}
+	data := new(BasicStateBag)
+
+	outcomeCh := make(chan Outcome)
+	go func() {
+		outcomeCh <- RunWithSignals(context.Background(), r, data, syscall.SIGUSR1)
+	}()
+
+	<-step.Started
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+
+	select {
+	case outcome := <-outcomeCh:
+		if outcome != OutcomeCancelled {
+			t.Fatalf("bad outcome: %v", outcome)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run should be cancelled")
+	}
+
+	// The cleanups finished before it returned
+	if !step.CleanedUp {
+		t.Fatal("step should be cleaned up")
+	}
+	if _, ok := data.GetOk("data"); ok {
+		t.Fatal("next step should not run")
+	}
+}
+
+func TestRunWithSignals_Completed(t *testing.T) {
+	r := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}}}
+	if outcome := RunWithSignals(context.Background(), r, new(BasicStateBag), syscall.SIGUSR1); outcome != OutcomeCompleted {
+		t.Fatalf("bad outcome: %v", outcome)
+	}
+}
+
+func TestRunWithSignals_Force(t *testing.T) {
+	started := make(chan struct{})
+	cleaning := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	// A step whose cleanup hangs
+	step := &FuncStep{
+		RunFunc: func(ctx context.Context, _ StateBag) StepAction {
+			close(started)
+			<-ctx.Done()
+			return ActionHalt
+		},
+		CleanupFunc: func(StateBag) {
+			close(cleaning)
+			<-release
+		},
+	}
+	r := &BasicRunner{Steps: []Step{step}}
+
+	outcomeCh := make(chan Outcome)
+	go func() {
+		outcomeCh <- RunWithSignals(context.Background(), r, new(BasicStateBag), syscall.SIGUSR1)
+	}()
+
+	<-started
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+
+	// Wait for the cancel to get through to the hanging cleanup
+	<-cleaning
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+
+	select {
+	case outcome := <-outcomeCh:
+		if outcome != OutcomeCancelled {
+			t.Fatalf("bad outcome: %v", outcome)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second signal should return straight away")
+	}
+}
@@ -0,0 +1,97 @@
+package multistep
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// KeyedStateBag is a StateBag that can list its keys, like BasicStateBag
+// and SyncStateBag.
+type KeyedStateBag interface {
+	StateBag
+
+	// Keys returns the keys currently in the bag.
+	Keys() []string
+}
+
+// StateChange is a single key that differs between two state bags.
+type StateChange struct {
+	Key string
+
+	// Old is the value in the first bag, or nil if the key was added.
+	Old interface{}
+
+	// New is the value in the second bag, or nil if the key was removed.
+	New interface{}
+}
+
+// StateDiff is what changed between two state bags, as returned by
+// DiffStateBags. Each list is sorted by key.
+type StateDiff struct {
+	Added   []StateChange
+	Removed []StateChange
+	Changed []StateChange
+}
+
+// Empty reports whether the bags were the same.
+func (d StateDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns the diff one key to a line, with + for an added key, -
+// for a removed key and ~ for a changed one.
+func (d StateDiff) String() string {
+	var sb strings.Builder
+	for _, c := range d.Added {
+		fmt.Fprintf(&sb, "+ %s: %#v\n", c.Key, c.New)
+	}
+	for _, c := range d.Removed {
+		fmt.Fprintf(&sb, "- %s: %#v\n", c.Key, c.Old)
+	}
+	for _, c := range d.Changed {
+		fmt.Fprintf(&sb, "~ %s: %#v -> %#v\n", c.Key, c.Old, c.New)
+	}
+
+	return sb.String()
+}
+
+// DiffStateBags returns what changed from before to after, for example
+// between a Clone taken before a step and the bag after it. Values are
+// compared with reflect.DeepEqual.
+//
+// The keys to compare come from the Keys of both bags, and each key is
+// looked up in both bags with GetOk.
+func DiffStateBags(before, after KeyedStateBag) StateDiff {
+	keys := make(map[string]bool)
+	for _, b := range []KeyedStateBag{before, after} {
+		for _, k := range b.Keys() {
+			keys[k] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var d StateDiff
+	for _, k := range sorted {
+		old, inBefore := before.GetOk(k)
+		new, inAfter := after.GetOk(k)
+		c := StateChange{Key: k, Old: old, New: new}
+
+		switch {
+		case inBefore && !inAfter:
+			d.Removed = append(d.Removed, c)
+		case !inBefore && inAfter:
+			d.Added = append(d.Added, c)
+		case inBefore && inAfter && !reflect.DeepEqual(old, new):
+			d.Changed = append(d.Changed, c)
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,67 @@
+package multistep
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBasicStateBag_ImplKeyedStateBag(t *testing.T) {
+	var raw interface{}
+	raw = &BasicStateBag{}
+	if _, ok := raw.(KeyedStateBag); !ok {
+		t.Fatalf("must be a KeyedStateBag")
+	}
+}
+
+func TestDiffStateBags(t *testing.T) {
+	before := NewBasicStateBag(map[string]interface{}{
+		"same":    1,
+		"changed": "a",
+		"removed": true,
+		"slice":   []string{"x"},
+	})
+
+	after := before.Clone()
+	after.Put("changed", "b")
+	after.Remove("removed")
+	after.Put("added", 2)
+	after.Put("slice", []string{"x"})
+
+	d := DiffStateBags(before, after)
+	expected := StateDiff{
+		Added:   []StateChange{{Key: "added", New: 2}},
+		Removed: []StateChange{{Key: "removed", Old: true}},
+		Changed: []StateChange{{Key: "changed", Old: "a", New: "b"}},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Fatalf("unexpected diff: %#v", d)
+	}
+
+	if s := d.String(); s != "+ added: 2\n- removed: true\n~ changed: \"a\" -> \"b\"\n" {
+		t.Fatalf("bad string: %q", s)
+	}
+
+	if d.Empty() {
+		t.Fatal("diff should not be empty")
+	}
+	if !DiffStateBags(before, before.Clone()).Empty() {
+		t.Fatal("diff of a clone should be empty")
+	}
+}
+
+func TestDiffStateBags_Mixed(t *testing.T) {
+	// Any two bags that can list their keys can be compared
+	before := NewBasicStateBag(map[string]interface{}{"a": 1, "b": 2})
+	after := new(SyncStateBag)
+	after.Put("a", 1)
+	after.Put("c", 3)
+
+	d := DiffStateBags(before, after)
+	expected := StateDiff{
+		Added:   []StateChange{{Key: "c", New: 3}},
+		Removed: []StateChange{{Key: "b", Old: 2}},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Fatalf("unexpected diff: %#v", d)
+	}
+}
@@ -1,6 +1,11 @@
 package multistep
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -10,16 +15,36 @@ type StateBag interface {
 	Get(string) interface{}
 	GetOk(string) (interface{}, bool)
 	Put(string, interface{})
+	Remove(string)
 }
 
 // BasicStateBag implements StateBag by using a normal map underneath
 // protected by a RWMutex.
+//
+// Every method is safe for concurrent use with every other. Keys, Range,
+// Clone and Snapshot each work on a copy of the bag taken under the lock
+// in one go, so they see the bag as it was at one moment, however it is
+// changed while they run, and Range's callback may itself Put or Remove.
+// Restore puts its values one at a time, so another goroutine may see
+// some of them before the rest.
 type BasicStateBag struct {
 	data map[string]interface{}
 	l    sync.RWMutex
 	once sync.Once
 }
 
+// NewBasicStateBag returns a new BasicStateBag holding a copy of the given
+// data. The map itself is not kept, so later changes to it don't affect
+// the bag. A nil map gives an empty bag.
+func NewBasicStateBag(initial map[string]interface{}) *BasicStateBag {
+	b := new(BasicStateBag)
+	for k, v := range initial {
+		b.Put(k, v)
+	}
+
+	return b
+}
+
 func (b *BasicStateBag) Get(k string) interface{} {
 	result, _ := b.GetOk(k)
 	return result
@@ -45,3 +70,233 @@ func (b *BasicStateBag) Put(k string, v interface{}) {
 	// Write the data
 	b.data[k] = v
 }
+
+func (b *BasicStateBag) Remove(k string) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	delete(b.data, k)
+}
+
+// PutChanged puts the value into the bag under the key like Put, and
+// reports whether that changed the bag: true if the key was not set or
+// held a different value. Values are compared like CompareAndSwap does,
+// so a value that is not comparable always counts as a change.
+func (b *BasicStateBag) PutChanged(k string, v interface{}) bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	b.once.Do(func() {
+		b.data = make(map[string]interface{})
+	})
+
+	current, ok := b.data[k]
+	b.data[k] = v
+	return !ok || !equalValues(current, v)
+}
+
+// PutIfAbsent puts the value into the bag under the key only if the key is
+// not already set, and reports whether it did. The check and the write are
+// done atomically, so exactly one of several concurrent callers wins.
+func (b *BasicStateBag) PutIfAbsent(k string, v interface{}) bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if _, ok := b.data[k]; ok {
+		return false
+	}
+
+	b.once.Do(func() {
+		b.data = make(map[string]interface{})
+	})
+
+	b.data[k] = v
+	return true
+}
+
+// CompareAndSwap atomically replaces the value under the key with new if
+// the key is set and its current value equals old, and reports whether it
+// did.
+//
+// Values are compared with ==. If either value is not comparable, for
+// example a slice or a map, they are never equal and nothing is swapped,
+// rather than panicking.
+func (b *BasicStateBag) CompareAndSwap(k string, old, new interface{}) bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	current, ok := b.data[k]
+	if !ok || !equalValues(current, old) {
+		return false
+	}
+
+	b.data[k] = new
+	return true
+}
+
+// equalValues compares a and b with ==, treating values that are not
+// comparable as unequal.
+func equalValues(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	if !reflect.ValueOf(a).Comparable() || !reflect.ValueOf(b).Comparable() {
+		return false
+	}
+
+	return a == b
+}
+
+// Keys returns a sorted snapshot of the keys currently in the bag.
+func (b *BasicStateBag) Keys() []string {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	keys := make([]string, 0, len(b.data))
+	for k := range b.data {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Range calls f for each key and value in the bag, in no particular order,
+// until f returns false. It works on a snapshot of the bag taken before
+// the first call, and f is called without the bag locked, so f may use the
+// bag itself.
+func (b *BasicStateBag) Range(f func(key string, value interface{}) bool) {
+	b.l.RLock()
+	values := make(map[string]interface{}, len(b.data))
+	for k, v := range b.data {
+		values[k] = v
+	}
+	b.l.RUnlock()
+
+	for k, v := range values {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// Clone returns a new BasicStateBag holding a shallow copy of the data in
+// this bag. Later changes to either bag are not visible in the other,
+// although values that are themselves references (maps, pointers, etc.)
+// are shared.
+func (b *BasicStateBag) Clone() *BasicStateBag {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	result := new(BasicStateBag)
+	for k, v := range b.data {
+		result.Put(k, v)
+	}
+
+	return result
+}
+
+// Snapshot serializes the contents of the bag to JSON, for example to
+// checkpoint a long running sequence to disk.
+//
+// Values that can't be serialized to JSON are left out. In that case the
+// JSON for the rest of the bag is still returned, along with a
+// *SnapshotError listing the keys that were left out.
+func (b *BasicStateBag) Snapshot() ([]byte, error) {
+	b.l.RLock()
+	values := make(map[string]interface{}, len(b.data))
+	for k, v := range b.data {
+		values[k] = v
+	}
+	b.l.RUnlock()
+
+	var dropped []string
+	raw := make(map[string]json.RawMessage, len(values))
+	for k, v := range values {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			dropped = append(dropped, k)
+			continue
+		}
+
+		raw[k] = encoded
+	}
+
+	result, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dropped) > 0 {
+		sort.Strings(dropped)
+		return result, &SnapshotError{Keys: dropped}
+	}
+
+	return result, nil
+}
+
+// Restore puts every value in the given JSON, as made by Snapshot, into the
+// bag. Values come back as the types encoding/json decodes into an
+// interface{}, so for example numbers are restored as float64.
+func (b *BasicStateBag) Restore(data []byte) error {
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	for k, v := range values {
+		b.Put(k, v)
+	}
+
+	return nil
+}
+
+// SnapshotError is returned by Snapshot when some values in the bag could
+// not be serialized.
+type SnapshotError struct {
+	// Keys are the keys whose values were left out, sorted.
+	Keys []string
+}
+
+func (e *SnapshotError) Error() string {
+	return fmt.Sprintf("values could not be serialized for keys: %s", strings.Join(e.Keys, ", "))
+}
+
+// ResetState removes the keys that a runner sets to signal how a previous
+// run ended (StateCancelled, StateHalted and StateAborted), so that the
+// same state bag can be given to another run.
+func ResetState(state StateBag) {
+	state.Remove(StateCancelled)
+	state.Remove(StateHalted)
+	state.Remove(StateAborted)
+}
+
+// GetAs returns the value stored under k in the state bag as a T. The
+// zero value of T and false are returned if the key is not present or the
+// value stored is not a T.
+func GetAs[T any](state StateBag, k string) (T, bool) {
+	raw, ok := state.GetOk(k)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+
+	v, ok := raw.(T)
+	return v, ok
+}
+
+// GetString returns the string stored under k in the state bag. See GetAs.
+func GetString(state StateBag, k string) (string, bool) {
+	return GetAs[string](state, k)
+}
+
+// GetInt returns the int stored under k in the state bag. See GetAs.
+func GetInt(state StateBag, k string) (int, bool) {
+	return GetAs[int](state, k)
+}
+
+// GetBool returns the bool stored under k in the state bag. See GetAs.
+func GetBool(state StateBag, k string) (bool, bool) {
+	return GetAs[bool](state, k)
+}
@@ -1,6 +1,11 @@
 package multistep
 
 import (
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -28,3 +33,410 @@ func TestBasicStateBag(t *testing.T) {
 		t.Fatalf("bad")
 	}
 }
+
+func TestNewBasicStateBag(t *testing.T) {
+	initial := map[string]interface{}{"a": 1, "b": "two"}
+	b := NewBasicStateBag(initial)
+
+	if b.Get("a") != 1 || b.Get("b") != "two" {
+		t.Fatalf("bad: %#v", b.Keys())
+	}
+
+	// The bag has its own copy of the data
+	initial["c"] = 3
+	if _, ok := b.GetOk("c"); ok {
+		t.Fatal("should not see changes to the initial map")
+	}
+}
+
+func TestNewBasicStateBag_Nil(t *testing.T) {
+	b := NewBasicStateBag(nil)
+	if len(b.Keys()) != 0 {
+		t.Fatalf("bad: %#v", b.Keys())
+	}
+
+	b.Put("a", 1)
+	if b.Get("a") != 1 {
+		t.Fatalf("bad: %#v", b.Get("a"))
+	}
+}
+
+func TestGetAs(t *testing.T) {
+	b := new(BasicStateBag)
+	b.Put("string", "bar")
+	b.Put("int", 42)
+	b.Put("bool", true)
+	b.Put("error", errors.New("oops"))
+
+	if v, ok := GetString(b, "string"); !ok || v != "bar" {
+		t.Fatalf("bad: %#v %#v", v, ok)
+	}
+
+	if v, ok := GetInt(b, "int"); !ok || v != 42 {
+		t.Fatalf("bad: %#v %#v", v, ok)
+	}
+
+	if v, ok := GetBool(b, "bool"); !ok || !v {
+		t.Fatalf("bad: %#v %#v", v, ok)
+	}
+
+	if v, ok := GetAs[error](b, "error"); !ok || v.Error() != "oops" {
+		t.Fatalf("bad: %#v %#v", v, ok)
+	}
+
+	// Missing keys
+	if v, ok := GetString(b, "missing"); ok || v != "" {
+		t.Fatalf("bad: %#v %#v", v, ok)
+	}
+
+	// Wrong types
+	if v, ok := GetInt(b, "string"); ok || v != 0 {
+		t.Fatalf("bad: %#v %#v", v, ok)
+	}
+
+	if v, ok := GetAs[error](b, "int"); ok || v != nil {
+		t.Fatalf("bad: %#v %#v", v, ok)
+	}
+}
+
+func TestBasicStateBag_Clone(t *testing.T) {
+	b := new(BasicStateBag)
+	b.Put("foo", "bar")
+
+	c := b.Clone()
+	if c.Get("foo").(string) != "bar" {
+		t.Fatalf("bad: %#v", c.Get("foo"))
+	}
+
+	// Changes to one must not affect the other
+	b.Put("foo", "baz")
+	c.Put("new", true)
+
+	if c.Get("foo").(string) != "bar" {
+		t.Fatalf("bad: %#v", c.Get("foo"))
+	}
+
+	if _, ok := b.GetOk("new"); ok {
+		t.Fatal("original should not have new")
+	}
+}
+
+func TestBasicStateBag_Clone_Empty(t *testing.T) {
+	c := new(BasicStateBag).Clone()
+	if _, ok := c.GetOk("foo"); ok {
+		t.Fatal("should not have foo")
+	}
+
+	c.Put("foo", "bar")
+	if c.Get("foo").(string) != "bar" {
+		t.Fatalf("bad: %#v", c.Get("foo"))
+	}
+}
+
+func TestBasicStateBag_Keys(t *testing.T) {
+	b := new(BasicStateBag)
+	if keys := b.Keys(); len(keys) != 0 {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	b.Put("foo", 1)
+	b.Put("bar", 2)
+	b.Put("baz", 3)
+
+	expected := []string{"bar", "baz", "foo"}
+	if keys := b.Keys(); !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
+func TestBasicStateBag_Remove(t *testing.T) {
+	b := new(BasicStateBag)
+
+	// Removing from an empty bag is fine
+	b.Remove("foo")
+
+	b.Put("foo", "bar")
+	b.Remove("foo")
+
+	if _, ok := b.GetOk("foo"); ok {
+		t.Fatal("should not have foo")
+	}
+}
+
+func TestBasicStateBag_PutIfAbsent(t *testing.T) {
+	b := new(BasicStateBag)
+
+	if !b.PutIfAbsent("foo", "a") {
+		t.Fatal("should put a missing key")
+	}
+
+	if b.PutIfAbsent("foo", "b") {
+		t.Fatal("should not put an existing key")
+	}
+
+	if b.Get("foo") != "a" {
+		t.Fatalf("bad: %#v", b.Get("foo"))
+	}
+}
+
+func TestBasicStateBag_PutIfAbsent_Concurrent(t *testing.T) {
+	b := new(BasicStateBag)
+
+	var wg sync.WaitGroup
+	var won int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if b.PutIfAbsent("foo", i) {
+				atomic.AddInt32(&won, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if won != 1 {
+		t.Fatalf("exactly one caller should win: %d", won)
+	}
+}
+
+func TestBasicStateBag_PutChanged(t *testing.T) {
+	b := new(BasicStateBag)
+
+	if !b.PutChanged("foo", "a") {
+		t.Fatal("putting a missing key is a change")
+	}
+
+	if b.PutChanged("foo", "a") {
+		t.Fatal("putting the same value is not a change")
+	}
+
+	if !b.PutChanged("foo", "b") {
+		t.Fatal("putting a different value is a change")
+	}
+
+	if b.Get("foo") != "b" {
+		t.Fatalf("bad: %#v", b.Get("foo"))
+	}
+
+	b.PutChanged("bar", []string{"a"})
+	if !b.PutChanged("bar", []string{"a"}) {
+		t.Fatal("putting a value that isn't comparable is always a change")
+	}
+}
+
+func TestBasicStateBag_CompareAndSwap(t *testing.T) {
+	b := new(BasicStateBag)
+
+	if b.CompareAndSwap("foo", nil, "a") {
+		t.Fatal("should not swap a missing key")
+	}
+
+	b.Put("foo", "a")
+	if b.CompareAndSwap("foo", "b", "c") {
+		t.Fatal("should not swap a different value")
+	}
+
+	if !b.CompareAndSwap("foo", "a", "c") {
+		t.Fatal("should swap an equal value")
+	}
+
+	if b.Get("foo") != "c" {
+		t.Fatalf("bad: %#v", b.Get("foo"))
+	}
+
+	b.Put("foo", nil)
+	if !b.CompareAndSwap("foo", nil, "d") {
+		t.Fatal("should swap a nil value")
+	}
+}
+
+func TestBasicStateBag_CompareAndSwap_NotComparable(t *testing.T) {
+	b := new(BasicStateBag)
+	b.Put("foo", []string{"a"})
+
+	if b.CompareAndSwap("foo", []string{"a"}, "b") {
+		t.Fatal("should not swap a value that isn't comparable")
+	}
+
+	b.Put("foo", struct{ V interface{} }{[]string{"a"}})
+	if b.CompareAndSwap("foo", struct{ V interface{} }{[]string{"a"}}, "b") {
+		t.Fatal("should not swap a value that isn't comparable")
+	}
+}
+
+func TestBasicStateBag_Range(t *testing.T) {
+	b := new(BasicStateBag)
+	b.Put("a", 1)
+	b.Put("b", 2)
+
+	seen := make(map[string]interface{})
+	b.Range(func(k string, v interface{}) bool {
+		seen[k] = v
+
+		// Using the bag from the callback must not deadlock
+		b.Put("c", 3)
+		return true
+	})
+
+	expected := map[string]interface{}{"a": 1, "b": 2}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Fatalf("bad: %#v", seen)
+	}
+}
+
+func TestBasicStateBag_Range_Stop(t *testing.T) {
+	b := new(BasicStateBag)
+	b.Put("a", 1)
+	b.Put("b", 2)
+
+	calls := 0
+	b.Range(func(string, interface{}) bool {
+		calls++
+		return false
+	})
+
+	if calls != 1 {
+		t.Fatalf("bad calls: %d", calls)
+	}
+}
+
+func TestResetState(t *testing.T) {
+	b := new(BasicStateBag)
+	b.Put("foo", "bar")
+	b.Put(StateCancelled, true)
+	b.Put(StateHalted, true)
+	b.Put(StateAborted, true)
+
+	ResetState(b)
+
+	if _, ok := b.GetOk(StateCancelled); ok {
+		t.Fatal("should not have cancelled")
+	}
+
+	if _, ok := b.GetOk(StateHalted); ok {
+		t.Fatal("should not have halted")
+	}
+
+	if _, ok := b.GetOk(StateAborted); ok {
+		t.Fatal("should not have aborted")
+	}
+
+	if _, ok := b.GetOk("foo"); !ok {
+		t.Fatal("should have foo")
+	}
+}
+
+func TestBasicStateBag_Snapshot(t *testing.T) {
+	b := new(BasicStateBag)
+	b.Put("string", "bar")
+	b.Put("number", 42)
+	b.Put("list", []string{"a", "b"})
+
+	data, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	restored := new(BasicStateBag)
+	if err := restored.Restore(data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"string": "bar",
+		"number": float64(42),
+		"list":   []interface{}{"a", "b"},
+	}
+	for k, v := range expected {
+		if actual := restored.Get(k); !reflect.DeepEqual(actual, v) {
+			t.Errorf("%s: bad: %#v", k, actual)
+		}
+	}
+}
+
+func TestBasicStateBag_Snapshot_Unserializable(t *testing.T) {
+	b := new(BasicStateBag)
+	b.Put("string", "bar")
+	b.Put("func", func() {})
+	b.Put("chan", make(chan int))
+
+	data, err := b.Snapshot()
+	snapErr, ok := err.(*SnapshotError)
+	if !ok {
+		t.Fatalf("bad err: %#v", err)
+	}
+
+	if !reflect.DeepEqual(snapErr.Keys, []string{"chan", "func"}) {
+		t.Fatalf("bad keys: %#v", snapErr.Keys)
+	}
+
+	// The serializable values are still there
+	restored := new(BasicStateBag)
+	if err := restored.Restore(data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual(restored.Keys(), []string{"string"}) {
+		t.Fatalf("bad keys: %#v", restored.Keys())
+	}
+}
+
+// Run with -race: every method must be safe alongside every other.
+func TestBasicStateBag_Concurrent(t *testing.T) {
+	b := new(BasicStateBag)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			k := strconv.Itoa(i % 4)
+			for j := 0; j < 200; j++ {
+				switch j % 6 {
+				case 0:
+					b.Put(k, j)
+				case 1:
+					b.Remove(k)
+				case 2:
+					b.Keys()
+				case 3:
+					// Changing the bag from inside Range is fine
+					b.Range(func(key string, _ interface{}) bool {
+						b.Remove(key)
+						return true
+					})
+				case 4:
+					b.Clone()
+				case 5:
+					b.Snapshot()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestBasicStateBag_Keys_Snapshot(t *testing.T) {
+	b := NewBasicStateBag(map[string]interface{}{"a": 1, "b": 2})
+
+	keys := b.Keys()
+	b.Remove("a")
+	b.Put("c", 3)
+
+	// The keys taken earlier are not affected
+	if !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	var seen []string
+	b.Range(func(k string, _ interface{}) bool {
+		seen = append(seen, k)
+		b.Remove("b")
+		b.Remove("c")
+		return true
+	})
+	if len(seen) != 2 {
+		t.Fatalf("range should see the bag as it was when it started: %#v", seen)
+	}
+}
@@ -0,0 +1,79 @@
+package multistep
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// SyncStateBag implements StateBag with a sync.Map underneath instead of
+// a map behind a single lock. Reads of keys that aren't being written
+// don't contend with each other at all, so it suits state that is written
+// once and then read a lot from many goroutines at a time, for example by
+// the steps of a ParallelRunner. Writes are slower than BasicStateBag's;
+// see BenchmarkStateBag_ConcurrentGet.
+//
+// The zero value is an empty bag ready to use. A SyncStateBag must not be
+// copied after first use.
+type SyncStateBag struct {
+	data sync.Map
+}
+
+func (b *SyncStateBag) Get(k string) interface{} {
+	result, _ := b.GetOk(k)
+	return result
+}
+
+func (b *SyncStateBag) GetOk(k string) (interface{}, bool) {
+	return b.data.Load(k)
+}
+
+func (b *SyncStateBag) Put(k string, v interface{}) {
+	b.data.Store(k, v)
+}
+
+func (b *SyncStateBag) Remove(k string) {
+	b.data.Delete(k)
+}
+
+// PutIfAbsent puts the value into the bag under the key only if the key is
+// not already set, and reports whether it did, like
+// BasicStateBag.PutIfAbsent.
+func (b *SyncStateBag) PutIfAbsent(k string, v interface{}) bool {
+	_, loaded := b.data.LoadOrStore(k, v)
+	return !loaded
+}
+
+// CompareAndSwap atomically replaces the value under the key with new if
+// the key is set and its current value equals old, and reports whether it
+// did. Values are compared like BasicStateBag.CompareAndSwap does.
+func (b *SyncStateBag) CompareAndSwap(k string, old, new interface{}) bool {
+	// sync.Map panics on an old value that is not comparable
+	if old != nil && !reflect.ValueOf(old).Comparable() {
+		return false
+	}
+
+	return b.data.CompareAndSwap(k, old, new)
+}
+
+// Keys returns a sorted snapshot of the keys currently in the bag.
+func (b *SyncStateBag) Keys() []string {
+	var keys []string
+	b.data.Range(func(k, _ interface{}) bool {
+		keys = append(keys, k.(string))
+		return true
+	})
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Range calls f for each key and value in the bag, in no particular order,
+// until f returns false. f may use the bag itself, but unlike
+// BasicStateBag.Range it doesn't see a snapshot: changes made while
+// ranging may or may not be visited.
+func (b *SyncStateBag) Range(f func(key string, value interface{}) bool) {
+	b.data.Range(func(k, v interface{}) bool {
+		return f(k.(string), v)
+	})
+}
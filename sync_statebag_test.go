@@ -0,0 +1,181 @@
+package multistep
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSyncStateBag_ImplStateBag(t *testing.T) {
+	var raw interface{}
+	raw = &SyncStateBag{}
+	if _, ok := raw.(StateBag); !ok {
+		t.Fatalf("must be a StateBag")
+	}
+}
+
+func TestSyncStateBag(t *testing.T) {
+	b := new(SyncStateBag)
+	if b.Get("foo") != nil {
+		t.Fatalf("bad: %#v", b.Get("foo"))
+	}
+
+	if _, ok := b.GetOk("foo"); ok {
+		t.Fatal("should not have foo")
+	}
+
+	b.Put("foo", "bar")
+	if b.Get("foo").(string) != "bar" {
+		t.Fatalf("bad")
+	}
+
+	// A nil value is still set
+	b.Put("nil", nil)
+	if v, ok := b.GetOk("nil"); !ok || v != nil {
+		t.Fatalf("bad: %#v %v", v, ok)
+	}
+
+	b.Remove("foo")
+	if _, ok := b.GetOk("foo"); ok {
+		t.Fatal("foo should be removed")
+	}
+
+	// Removing a missing key is fine
+	b.Remove("missing")
+}
+
+func TestSyncStateBag_PutIfAbsent(t *testing.T) {
+	b := new(SyncStateBag)
+	if !b.PutIfAbsent("foo", "a") {
+		t.Fatal("first put should win")
+	}
+
+	if b.PutIfAbsent("foo", "b") {
+		t.Fatal("second put should lose")
+	}
+
+	if v := b.Get("foo"); v != "a" {
+		t.Fatalf("bad: %#v", v)
+	}
+}
+
+func TestSyncStateBag_CompareAndSwap(t *testing.T) {
+	b := new(SyncStateBag)
+	if b.CompareAndSwap("foo", nil, "a") {
+		t.Fatal("should not swap a missing key")
+	}
+
+	b.Put("foo", "a")
+	if !b.CompareAndSwap("foo", "a", "b") {
+		t.Fatal("should swap")
+	}
+
+	if b.CompareAndSwap("foo", "a", "c") {
+		t.Fatal("should not swap a different value")
+	}
+
+	// Values that aren't comparable never match, without panicking
+	b.Put("slice", []string{"a"})
+	if b.CompareAndSwap("slice", []string{"a"}, "b") {
+		t.Fatal("should not swap a slice")
+	}
+
+	if v := b.Get("foo"); v != "b" {
+		t.Fatalf("bad: %#v", v)
+	}
+}
+
+func TestSyncStateBag_KeysRange(t *testing.T) {
+	b := new(SyncStateBag)
+	b.Put("b", 2)
+	b.Put("a", 1)
+
+	if keys := b.Keys(); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	values := make(map[string]interface{})
+	b.Range(func(k string, v interface{}) bool {
+		values[k] = v
+		return true
+	})
+	if !reflect.DeepEqual(values, map[string]interface{}{"a": 1, "b": 2}) {
+		t.Fatalf("bad: %#v", values)
+	}
+}
+
+func TestSyncStateBag_Concurrent(t *testing.T) {
+	b := new(SyncStateBag)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			k := strconv.Itoa(i)
+			b.Put(k, i)
+			b.Get(k)
+			b.PutIfAbsent("shared", i)
+			b.Remove(k)
+		}(i)
+	}
+	wg.Wait()
+
+	if keys := b.Keys(); !reflect.DeepEqual(keys, []string{"shared"}) {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
+// BenchmarkStateBag_ConcurrentGet compares the bags under concurrent reads
+// of a few keys that are written once up front, which is what
+// SyncStateBag is for. Run it with -cpu to see how each scales.
+func BenchmarkStateBag_ConcurrentGet(b *testing.B) {
+	bags := []struct {
+		name  string
+		state StateBag
+	}{
+		{"BasicStateBag", new(BasicStateBag)},
+		{"SyncStateBag", new(SyncStateBag)},
+	}
+
+	for _, bag := range bags {
+		for i := 0; i < 16; i++ {
+			bag.state.Put(strconv.Itoa(i), i)
+		}
+
+		b.Run(bag.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					bag.state.Get(strconv.Itoa(i % 16))
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkStateBag_ConcurrentPut is the other side of the tradeoff:
+// concurrent writes.
+func BenchmarkStateBag_ConcurrentPut(b *testing.B) {
+	bags := []struct {
+		name  string
+		state StateBag
+	}{
+		{"BasicStateBag", new(BasicStateBag)},
+		{"SyncStateBag", new(SyncStateBag)},
+	}
+
+	for _, bag := range bags {
+		b.Run(bag.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					bag.state.Put(strconv.Itoa(i%16), i)
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package multistep
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TimeoutStep wraps a step so that its Run is given a context that is
+// cancelled after Timeout.
+//
+// If the timeout fires, TimeoutStep returns ActionHalt regardless of what
+// the wrapped step returned, and puts an error wrapping
+// context.DeadlineExceeded into the state bag under StateError.
+type TimeoutStep struct {
+	// Step is the step to run.
+	Step Step
+
+	// Timeout is how long the wrapped step's Run is allowed to take.
+	Timeout time.Duration
+
+	// Clock, if set, is used to measure the timeout instead of the real
+	// time, for tests.
+	Clock Clock
+}
+
+func (s *TimeoutStep) Run(ctx context.Context, state StateBag) StepAction {
+	stepCtx, cancel := withClockTimeout(ctx, s.Clock, s.Timeout)
+	defer cancel()
+
+	action := s.Step.Run(stepCtx, state)
+
+	// Only report a timeout if it was our deadline that fired, not a
+	// cancellation of the parent.
+	if ctx.Err() == nil && stepCtx.Err() != nil {
+		return Halt(state, fmt.Errorf("step timed out after %s: %w", s.Timeout, context.Cause(stepCtx)))
+	}
+
+	return action
+}
+
+func (s *TimeoutStep) Cleanup(state StateBag) {
+	s.Step.Cleanup(state)
+}
+
+func (s *TimeoutStep) Unwrap() Step {
+	return s.Step
+}
@@ -0,0 +1,104 @@
+package multistep
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimeoutStep_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &TimeoutStep{}
+	if _, ok := raw.(Step); !ok {
+		t.Fatalf("TimeoutStep must be a Step")
+	}
+}
+
+func TestTimeoutStep(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TimeoutStep{Step: &TestStepAcc{Data: "a"}, Timeout: time.Minute}
+
+	r := &BasicRunner{Steps: []Step{step}}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk(StateHalted); ok {
+		t.Errorf("halted should not be in state bag")
+	}
+
+	if _, ok := data.GetOk(StateError); ok {
+		t.Errorf("error should not be in state bag")
+	}
+
+	if _, ok := data.GetOk("cleanup"); !ok {
+		t.Errorf("wrapped step should be cleaned up")
+	}
+}
+
+func TestTimeoutStep_Timeout(t *testing.T) {
+	data := new(BasicStateBag)
+	inner := &TestStepWaitCancel{}
+	step := &TimeoutStep{Step: inner, Timeout: 10 * time.Millisecond}
+
+	r := &BasicRunner{Steps: []Step{step, &TestStepAcc{Data: "b"}}}
+	r.Run(context.Background(), data)
+
+	if _, ok := data.GetOk(StateHalted); !ok {
+		t.Errorf("halted should be in state bag")
+	}
+
+	err, ok := data.Get(StateError).(error)
+	if !ok || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("bad error: %#v", data.Get(StateError))
+	}
+
+	if _, ok := data.GetOk("data"); ok {
+		t.Errorf("next step should not have run")
+	}
+
+	if !inner.CleanedUp {
+		t.Errorf("wrapped step should be cleaned up")
+	}
+}
+
+func TestTimeoutStep_Optional(t *testing.T) {
+	data := new(BasicStateBag)
+	step := &TestStepCleanupContext{TestStepAcc: TestStepAcc{Data: "a"}}
+
+	wrapped := &TimeoutStep{Step: &RetryStep{Step: step, MaxAttempts: 1}, Timeout: time.Minute}
+	r := &BasicRunner{Steps: []Step{wrapped}}
+	r.Run(context.Background(), data)
+
+	if step.Ctx == nil {
+		t.Fatal("CleanupWithContext should be called through the wrappers")
+	}
+}
+
+func TestTimeoutStep_Clock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	data := new(BasicStateBag)
+	inner := &TestStepWaitCancel{Started: make(chan struct{})}
+	step := &TimeoutStep{Step: inner, Timeout: time.Hour, Clock: clock}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r := &BasicRunner{Steps: []Step{step}}
+		r.Run(context.Background(), data)
+		close(doneCh)
+	}()
+
+	<-inner.Started
+	clock.WaitForWaiters(1)
+	clock.Advance(time.Hour)
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("step should time out on the fake clock")
+	}
+
+	err := GetError(data)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("bad error: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package multistep
+
+import (
+	"context"
+	"errors"
+)
+
+// Tracer starts spans for tracing steps. It is a small subset of what
+// tracing libraries such as OpenTelemetry provide, so that they can be
+// plugged in with a thin adapter without this package depending on them.
+type Tracer interface {
+	// Start starts a span with the given name as a child of the span
+	// carried by ctx, if any, and returns a context carrying the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation started by a Tracer.
+type Span interface {
+	// SetAttribute records an attribute on the span.
+	SetAttribute(key, value string)
+
+	// SetError marks the span as failed with the given error.
+	SetError(err error)
+
+	// End ends the span.
+	End()
+}
+
+// TracingStep wraps a step so that each run of it is traced as a span,
+// named after the step as returned by StepName. The action returned by the
+// step is recorded under the "multistep.action" attribute, and the span is
+// marked as failed if the step halts, with the error under StateError if
+// there is one.
+//
+// The span is started from the context given to Run, and the step runs
+// with the span's context, so the spans of nested runners become children
+// of the span of the step running them.
+type TracingStep struct {
+	// Step is the step to run.
+	Step Step
+
+	// Tracer starts the spans.
+	Tracer Tracer
+}
+
+// TracingMiddleware returns middleware that wraps every step in a
+// TracingStep using the given tracer.
+func TracingMiddleware(tracer Tracer) StepMiddleware {
+	return func(step Step) Step {
+		return &TracingStep{Step: step, Tracer: tracer}
+	}
+}
+
+func (s *TracingStep) InnerStepName() string {
+	return StepName(s.Step)
+}
+
+func (s *TracingStep) Run(ctx context.Context, state StateBag) StepAction {
+	ctx, span := s.Tracer.Start(ctx, StepName(s.Step))
+	defer span.End()
+
+	action := s.Step.Run(ctx, state)
+	span.SetAttribute("multistep.action", action.String())
+
+	if action == ActionHalt {
+		err, ok := state.Get(StateError).(error)
+		if !ok {
+			err = errors.New("step halted")
+		}
+
+		span.SetError(err)
+	}
+
+	return action
+}
+
+func (s *TracingStep) Cleanup(state StateBag) {
+	s.Step.Cleanup(state)
+}
+
+func (s *TracingStep) Unwrap() Step {
+	return s.Step
+}
@@ -0,0 +1,118 @@
+package multistep
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testTracerKey struct{}
+
+// A tracer recording the spans it starts
+type testTracer struct {
+	Spans []*testSpan
+
+	l sync.Mutex
+}
+
+type testSpan struct {
+	Name       string
+	Parent     *testSpan
+	Attributes map[string]string
+	Err        error
+	Ended      bool
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(testTracerKey{}).(*testSpan)
+	span := &testSpan{Name: name, Parent: parent, Attributes: map[string]string{}}
+
+	t.l.Lock()
+	defer t.l.Unlock()
+	t.Spans = append(t.Spans, span)
+
+	return context.WithValue(ctx, testTracerKey{}, span), span
+}
+
+func (s *testSpan) SetAttribute(key, value string) { s.Attributes[key] = value }
+func (s *testSpan) SetError(err error)             { s.Err = err }
+func (s *testSpan) End()                           { s.Ended = true }
+
+func TestTracingStep_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &TracingStep{}
+	if _, ok := raw.(Step); !ok {
+		t.Fatalf("TracingStep must be a Step")
+	}
+}
+
+func TestTracingStep(t *testing.T) {
+	tracer := new(testTracer)
+	data := new(BasicStateBag)
+	r := &BasicRunner{
+		Steps: []Step{
+			&NamedStep{StepName: "a", Step: &TestStepAcc{Data: "a"}},
+			&TestStepAcc{Data: "b"},
+		},
+		Middleware: []StepMiddleware{TracingMiddleware(tracer)},
+	}
+	r.Run(context.Background(), data)
+
+	if len(tracer.Spans) != 2 {
+		t.Fatalf("bad spans: %#v", tracer.Spans)
+	}
+
+	for i, name := range []string{"a", "TestStepAcc"} {
+		span := tracer.Spans[i]
+		if span.Name != name || !span.Ended || span.Err != nil {
+			t.Errorf("bad span: %#v", span)
+		}
+
+		if span.Attributes["multistep.action"] != "ActionContinue" {
+			t.Errorf("bad attributes: %#v", span.Attributes)
+		}
+	}
+
+	results := data.Get("cleanup").([]string)
+	if !reflect.DeepEqual(results, []string{"b", "a"}) {
+		t.Errorf("wrapped steps should be cleaned up: %#v", results)
+	}
+}
+
+func TestTracingStep_Halt(t *testing.T) {
+	tracer := new(testTracer)
+	haltErr := errors.New("boom")
+	step := &FuncStep{RunFunc: func(_ context.Context, state StateBag) StepAction {
+		return Halt(state, haltErr)
+	}}
+
+	r := &BasicRunner{Steps: []Step{&TracingStep{Step: step, Tracer: tracer}}}
+	r.Run(context.Background(), new(BasicStateBag))
+
+	span := tracer.Spans[0]
+	if span.Attributes["multistep.action"] != "ActionHalt" {
+		t.Errorf("bad attributes: %#v", span.Attributes)
+	}
+
+	if span.Err != haltErr {
+		t.Errorf("bad error: %#v", span.Err)
+	}
+}
+
+func TestTracingStep_Nested(t *testing.T) {
+	tracer := new(testTracer)
+	middleware := []StepMiddleware{TracingMiddleware(tracer)}
+	inner := &BasicRunner{Steps: []Step{&TestStepAcc{Data: "a"}}, Middleware: middleware}
+	outer := &BasicRunner{Steps: []Step{&RunnerStep{Runner: inner}}, Middleware: middleware}
+	outer.Run(context.Background(), new(BasicStateBag))
+
+	if len(tracer.Spans) != 2 {
+		t.Fatalf("bad spans: %#v", tracer.Spans)
+	}
+
+	if tracer.Spans[1].Parent != tracer.Spans[0] {
+		t.Fatal("span of the nested step should be a child of the outer step's span")
+	}
+}
@@ -0,0 +1,129 @@
+package multistep
+
+import "sync"
+
+// WatchableStateBag is a BasicStateBag that can be watched for changes to
+// individual keys, for example to drive a progress display.
+//
+// Each watcher gets a channel with a buffer of one value. Put never blocks
+// on a watcher: if the watcher hasn't received the previous value yet, that
+// value is dropped and replaced with the new one, so a slow watcher always
+// sees the latest value but may miss some in between. Put, PutChanged,
+// and a successful PutIfAbsent or CompareAndSwap notify watchers; Remove
+// does not.
+type WatchableStateBag struct {
+	BasicStateBag
+
+	watchers map[string][]chan interface{}
+	closed   bool
+	wl       sync.Mutex
+}
+
+func (w *WatchableStateBag) Put(k string, v interface{}) {
+	w.wl.Lock()
+	defer w.wl.Unlock()
+
+	w.BasicStateBag.Put(k, v)
+	w.notify(k, v)
+}
+
+func (w *WatchableStateBag) PutChanged(k string, v interface{}) bool {
+	w.wl.Lock()
+	defer w.wl.Unlock()
+
+	changed := w.BasicStateBag.PutChanged(k, v)
+	w.notify(k, v)
+	return changed
+}
+
+func (w *WatchableStateBag) PutIfAbsent(k string, v interface{}) bool {
+	w.wl.Lock()
+	defer w.wl.Unlock()
+
+	if !w.BasicStateBag.PutIfAbsent(k, v) {
+		return false
+	}
+
+	w.notify(k, v)
+	return true
+}
+
+func (w *WatchableStateBag) CompareAndSwap(k string, old, new interface{}) bool {
+	w.wl.Lock()
+	defer w.wl.Unlock()
+
+	if !w.BasicStateBag.CompareAndSwap(k, old, new) {
+		return false
+	}
+
+	w.notify(k, new)
+	return true
+}
+
+// notify sends v to the watchers of k. The caller must hold wl.
+func (w *WatchableStateBag) notify(k string, v interface{}) {
+	for _, ch := range w.watchers[k] {
+		// Drop the pending value, if any, to make room for this one. We
+		// are the only sender, so the send below can't block.
+		select {
+		case <-ch:
+		default:
+		}
+
+		ch <- v
+	}
+}
+
+// Watch returns a channel that receives the new value each time k is Put.
+// The channel is closed by Unwatch or Close. If the bag is already closed,
+// the channel returned is closed too.
+func (w *WatchableStateBag) Watch(k string) <-chan interface{} {
+	w.wl.Lock()
+	defer w.wl.Unlock()
+
+	ch := make(chan interface{}, 1)
+	if w.closed {
+		close(ch)
+		return ch
+	}
+
+	if w.watchers == nil {
+		w.watchers = make(map[string][]chan interface{})
+	}
+	w.watchers[k] = append(w.watchers[k], ch)
+
+	return ch
+}
+
+// Unwatch stops the given channel, returned by Watch, from receiving
+// values and closes it.
+func (w *WatchableStateBag) Unwatch(ch <-chan interface{}) {
+	w.wl.Lock()
+	defer w.wl.Unlock()
+
+	for k, chs := range w.watchers {
+		for i, c := range chs {
+			if c == ch {
+				w.watchers[k] = append(chs[:i], chs[i+1:]...)
+				close(c)
+				return
+			}
+		}
+	}
+}
+
+// Close closes every channel returned by Watch. The bag can still be used
+// afterwards, but it can't be watched any more.
+func (w *WatchableStateBag) Close() {
+	w.wl.Lock()
+	defer w.wl.Unlock()
+
+	for _, chs := range w.watchers {
+		for _, ch := range chs {
+			close(ch)
+		}
+	}
+
+	w.watchers = nil
+	w.closed = true
+}
@@ -0,0 +1,111 @@
+package multistep
+
+import (
+	"testing"
+)
+
+func TestWatchableStateBag_ImplStateBag(t *testing.T) {
+	var raw interface{}
+	raw = &WatchableStateBag{}
+	if _, ok := raw.(StateBag); !ok {
+		t.Fatalf("must be a StateBag")
+	}
+}
+
+func TestWatchableStateBag_Watch(t *testing.T) {
+	b := new(WatchableStateBag)
+	ch := b.Watch("progress")
+
+	b.Put("other", 1)
+	b.Put("progress", 10)
+
+	if v := <-ch; v != 10 {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	// A slow watcher only sees the latest value
+	b.Put("progress", 20)
+	b.Put("progress", 30)
+
+	if v := <-ch; v != 30 {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	select {
+	case v := <-ch:
+		t.Fatalf("unexpected value: %#v", v)
+	default:
+	}
+
+	if b.Get("progress") != 30 {
+		t.Fatalf("bad: %#v", b.Get("progress"))
+	}
+}
+
+func TestWatchableStateBag_Watch_Puts(t *testing.T) {
+	b := new(WatchableStateBag)
+	ch := b.Watch("k")
+
+	b.PutChanged("k", 1)
+	if v := <-ch; v != 1 {
+		t.Fatalf("PutChanged: bad: %#v", v)
+	}
+
+	b.Remove("k")
+	b.PutIfAbsent("k", 2)
+	if v := <-ch; v != 2 {
+		t.Fatalf("PutIfAbsent: bad: %#v", v)
+	}
+
+	b.CompareAndSwap("k", 2, 3)
+	if v := <-ch; v != 3 {
+		t.Fatalf("CompareAndSwap: bad: %#v", v)
+	}
+
+	// Only a successful put notifies
+	b.PutIfAbsent("k", 4)
+	b.CompareAndSwap("k", 2, 5)
+	select {
+	case v := <-ch:
+		t.Fatalf("unexpected value: %#v", v)
+	default:
+	}
+}
+
+func TestWatchableStateBag_Unwatch(t *testing.T) {
+	b := new(WatchableStateBag)
+	ch := b.Watch("progress")
+	other := b.Watch("progress")
+
+	b.Unwatch(ch)
+	b.Put("progress", 10)
+
+	if _, ok := <-ch; ok {
+		t.Fatal("channel should be closed")
+	}
+
+	if v := <-other; v != 10 {
+		t.Fatalf("bad: %#v", v)
+	}
+}
+
+func TestWatchableStateBag_Close(t *testing.T) {
+	b := new(WatchableStateBag)
+	ch := b.Watch("progress")
+
+	b.Close()
+
+	if _, ok := <-ch; ok {
+		t.Fatal("channel should be closed")
+	}
+
+	// Putting after closing still works
+	b.Put("progress", 10)
+	if b.Get("progress") != 10 {
+		t.Fatalf("bad: %#v", b.Get("progress"))
+	}
+
+	if _, ok := <-b.Watch("progress"); ok {
+		t.Fatal("channel should be closed")
+	}
+}